@@ -24,9 +24,13 @@ func NewCommandCmd() *cobra.Command {
 			if err != nil {
 				return err
 			}
+			defer func() { _ = sshProvider.Close() }()
+
+			ctx, stop := signalContext()
+			defer stop()
 
 			return cmd.Run(
-				context.Background(),
+				ctx,
 				sshProvider,
 				log.Default,
 			)
@@ -47,5 +51,5 @@ func (cmd *CommandCmd) Run(
 		return fmt.Errorf("command environment variable is missing")
 	}
 
-	return ssh.Command(providerSSH, command)
+	return ssh.Command(ctx, providerSSH, command)
 }
@@ -0,0 +1,48 @@
+package cmd
+
+import (
+	"context"
+
+	"github.com/skevetter/devpod-provider-ssh/pkg/ssh"
+	"github.com/skevetter/log"
+	"github.com/spf13/cobra"
+)
+
+// CreateCmd holds the cmd flags
+type CreateCmd struct{}
+
+// NewCreateCmd defines a create
+func NewCreateCmd() *cobra.Command {
+	cmd := &CreateCmd{}
+	createCmd := &cobra.Command{
+		Use:   "create",
+		Short: "Create the machine directory on the host",
+		RunE: func(_ *cobra.Command, args []string) error {
+			sshProvider, err := ssh.NewProvider(log.Default)
+			if err != nil {
+				return err
+			}
+			defer func() { _ = sshProvider.Close() }()
+
+			ctx, stop := signalContext()
+			defer stop()
+
+			return cmd.Run(
+				ctx,
+				sshProvider,
+				log.Default,
+			)
+		},
+	}
+
+	return createCmd
+}
+
+// Run runs the create logic
+func (cmd *CreateCmd) Run(
+	ctx context.Context,
+	providerSSH *ssh.SSHProvider,
+	logs log.Logger,
+) error {
+	return ssh.Create(ctx, providerSSH)
+}
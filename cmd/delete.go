@@ -0,0 +1,57 @@
+package cmd
+
+import (
+	"context"
+
+	"github.com/skevetter/devpod-provider-ssh/pkg/ssh"
+	"github.com/skevetter/log"
+	"github.com/spf13/cobra"
+)
+
+// DeleteCmd holds the cmd flags
+type DeleteCmd struct {
+	KeepContainers bool
+}
+
+// NewDeleteCmd defines a delete
+func NewDeleteCmd() *cobra.Command {
+	cmd := &DeleteCmd{}
+	deleteCmd := &cobra.Command{
+		Use:   "delete",
+		Short: "Delete the machine files from the host",
+		RunE: func(_ *cobra.Command, args []string) error {
+			sshProvider, err := ssh.NewProvider(log.Default)
+			if err != nil {
+				return err
+			}
+			defer func() { _ = sshProvider.Close() }()
+
+			ctx, stop := signalContext()
+			defer stop()
+
+			return cmd.Run(
+				ctx,
+				sshProvider,
+				log.Default,
+			)
+		},
+	}
+	deleteCmd.Flags().BoolVar(
+		&cmd.KeepContainers,
+		"keep-containers",
+		false,
+		"Keep the workspace containers on the host",
+	)
+
+	return deleteCmd
+}
+
+// Run runs the delete logic
+func (cmd *DeleteCmd) Run(
+	ctx context.Context,
+	providerSSH *ssh.SSHProvider,
+	logs log.Logger,
+) error {
+	keepContainers := cmd.KeepContainers || providerSSH.Config.KeepContainers
+	return ssh.Delete(ctx, providerSSH, keepContainers)
+}
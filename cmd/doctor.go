@@ -0,0 +1,106 @@
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"text/tabwriter"
+
+	"github.com/skevetter/devpod-provider-ssh/pkg/ssh"
+	"github.com/skevetter/log"
+	"github.com/spf13/cobra"
+)
+
+// DoctorCmd holds the cmd flags
+type DoctorCmd struct {
+	JSON bool
+}
+
+// NewDoctorCmd defines a doctor
+func NewDoctorCmd() *cobra.Command {
+	cmd := &DoctorCmd{}
+	doctorCmd := &cobra.Command{
+		Use:   "doctor",
+		Short: "Diagnose the connection to the host",
+		RunE: func(_ *cobra.Command, args []string) error {
+			sshProvider, err := ssh.NewProvider(log.Default)
+			if err != nil {
+				return err
+			}
+			defer func() { _ = sshProvider.Close() }()
+
+			ctx, stop := signalContext()
+			defer stop()
+
+			return cmd.Run(
+				ctx,
+				sshProvider,
+				log.Default,
+			)
+		},
+	}
+	doctorCmd.Flags().BoolVar(&cmd.JSON, "json", false, "Print the checks as JSON")
+
+	return doctorCmd
+}
+
+// Run runs the doctor logic
+func (cmd *DoctorCmd) Run(
+	ctx context.Context,
+	providerSSH *ssh.SSHProvider,
+	logs log.Logger,
+) error {
+	checks, err := ssh.Doctor(ctx, providerSSH)
+	if err != nil {
+		return err
+	}
+
+	if cmd.JSON {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		err = encoder.Encode(checks)
+	} else {
+		err = printChecks(checks)
+	}
+	if err != nil {
+		return err
+	}
+
+	failures := 0
+	for _, check := range checks {
+		if check.Result == ssh.CheckFailed {
+			failures++
+		}
+	}
+	if failures > 0 {
+		return fmt.Errorf("%d of %d checks failed", failures, len(checks))
+	}
+
+	return nil
+}
+
+// printChecks prints the checks as a table followed by the fixes of the
+// failed ones.
+func printChecks(checks []ssh.Check) error {
+	writer := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	_, _ = fmt.Fprintln(writer, "CHECK\tRESULT\tDETAIL")
+	for _, check := range checks {
+		_, _ = fmt.Fprintf(writer, "%s\t%s\t%s\n", check.Name, check.Result, check.Detail)
+	}
+	err := writer.Flush()
+	if err != nil {
+		return err
+	}
+
+	for _, check := range checks {
+		if check.Fix != "" {
+			_, err = fmt.Printf("\n%s: %s\n", check.Name, check.Fix)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,78 @@
+package cmd
+
+import (
+	"context"
+
+	"github.com/skevetter/devpod-provider-ssh/pkg/ssh"
+	"github.com/skevetter/log"
+	"github.com/spf13/cobra"
+)
+
+// ForwardCmd holds the cmd flags
+type ForwardCmd struct {
+	Local   []string
+	Remote  []string
+	Dynamic []string
+}
+
+// NewForwardCmd defines a forward
+func NewForwardCmd() *cobra.Command {
+	cmd := &ForwardCmd{}
+	forwardCmd := &cobra.Command{
+		Use:   "forward",
+		Short: "Forward ports to and from the host until interrupted",
+		Args:  cobra.NoArgs,
+		RunE: func(_ *cobra.Command, args []string) error {
+			sshProvider, err := ssh.NewProvider(log.Default)
+			if err != nil {
+				return err
+			}
+			defer func() { _ = sshProvider.Close() }()
+
+			ctx, stop := signalContext()
+			defer stop()
+
+			return cmd.Run(
+				ctx,
+				sshProvider,
+				log.Default,
+			)
+		},
+	}
+	forwardCmd.Flags().StringArrayVarP(
+		&cmd.Local,
+		"local",
+		"L",
+		nil,
+		"Forward a local port to the host, [bind_address:]port:host:hostport",
+	)
+	forwardCmd.Flags().StringArrayVarP(
+		&cmd.Remote,
+		"remote",
+		"R",
+		nil,
+		"Forward a port of the host to the local host, [bind_address:]port:host:hostport",
+	)
+	forwardCmd.Flags().StringArrayVarP(
+		&cmd.Dynamic,
+		"dynamic",
+		"D",
+		nil,
+		"Start a local SOCKS5 proxy that connects from the host, [bind_address:]port",
+	)
+
+	return forwardCmd
+}
+
+// Run runs the forward logic
+func (cmd *ForwardCmd) Run(
+	ctx context.Context,
+	providerSSH *ssh.SSHProvider,
+	logs log.Logger,
+) error {
+	return ssh.Forward(ctx, providerSSH, ssh.ForwardSpecs{
+		Local:   cmd.Local,
+		Remote:  cmd.Remote,
+		Dynamic: cmd.Dynamic,
+	})
+}
@@ -22,9 +22,13 @@ func NewInitCmd() *cobra.Command {
 			if err != nil {
 				return err
 			}
+			defer func() { _ = sshProvider.Close() }()
+
+			ctx, stop := signalContext()
+			defer stop()
 
 			return cmd.Run(
-				context.Background(),
+				ctx,
 				sshProvider,
 				log.Default,
 			)
@@ -40,5 +44,5 @@ func (cmd *InitCmd) Run(
 	providerSSH *ssh.SSHProvider,
 	logs log.Logger,
 ) error {
-	return ssh.Init(providerSSH)
+	return ssh.Init(ctx, providerSSH)
 }
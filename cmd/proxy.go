@@ -0,0 +1,48 @@
+package cmd
+
+import (
+	"context"
+
+	"github.com/skevetter/devpod-provider-ssh/pkg/ssh"
+	"github.com/skevetter/log"
+	"github.com/spf13/cobra"
+)
+
+// ProxyCmd holds the cmd flags
+type ProxyCmd struct{}
+
+// NewProxyCmd defines the ProxyCommand the ssh binary uses for PROXY_URL
+func NewProxyCmd() *cobra.Command {
+	cmd := &ProxyCmd{}
+	proxyCmd := &cobra.Command{
+		Use:    "proxy HOST PORT",
+		Short:  "Connect stdin and stdout to a host through PROXY_URL",
+		Hidden: true,
+		Args:   cobra.ExactArgs(2),
+		RunE: func(_ *cobra.Command, args []string) error {
+			sshProvider, err := ssh.NewProvider(log.Default)
+			if err != nil {
+				return err
+			}
+
+			return cmd.Run(
+				context.Background(),
+				sshProvider,
+				args[0],
+				args[1],
+			)
+		},
+	}
+
+	return proxyCmd
+}
+
+// Run runs the proxy logic
+func (cmd *ProxyCmd) Run(
+	ctx context.Context,
+	providerSSH *ssh.SSHProvider,
+	host string,
+	port string,
+) error {
+	return ssh.ProxyConnect(providerSSH, host, port)
+}
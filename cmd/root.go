@@ -1,9 +1,12 @@
 package cmd
 
 import (
+	"errors"
 	"os"
 	"os/exec"
+	"syscall"
 
+	providerssh "github.com/skevetter/devpod-provider-ssh/pkg/ssh"
 	"github.com/skevetter/log"
 	"github.com/spf13/cobra"
 	"golang.org/x/crypto/ssh"
@@ -29,24 +32,73 @@ func NewRootCmd() *cobra.Command {
 // Execute adds all child commands to the root command and sets flags appropriately.
 // This is called by main.main(). It only needs to happen once to the rootCmd.
 func Execute() {
+	// ssh runs the provider to answer its prompts with PASSWORD_COMMAND
+	if providerssh.IsAskpass(os.Args[1:]) {
+		err := providerssh.Askpass(os.Args[1:])
+		if err != nil {
+			log.Default.ErrorStreamOnly().Error(err)
+			os.Exit(1)
+		}
+		return
+	}
+
 	// build the root command
 	rootCmd := BuildRoot()
 
 	// execute command
 	err := rootCmd.Execute()
 	if err != nil {
-		if exitErr, ok := err.(*ssh.ExitError); ok {
-			os.Exit(exitErr.ExitStatus())
+		os.Exit(exitCode(err))
+	}
+}
+
+// exitCode returns the exit code of the provider for err. Remote commands
+// pass on their exit status, a remote command that was killed by a signal or
+// exited without status results in 255, the same as with the ssh binary. If
+// the provider was stopped by a signal it exits with 128 plus its number.
+func exitCode(err error) int {
+	signalErr := &providerssh.SignalError{}
+	if errors.As(err, &signalErr) {
+		log.Default.ErrorStreamOnly().Error(signalErr.Error())
+		if number, ok := signalErr.Signal.(syscall.Signal); ok {
+			return 128 + int(number)
 		}
-		if exitErr, ok := err.(*exec.ExitError); ok {
-			if len(exitErr.Stderr) > 0 {
-				log.Default.ErrorStreamOnly().Error(string(exitErr.Stderr))
-			}
-			os.Exit(exitErr.ExitCode())
+		return 1
+	}
+
+	sshExitErr := &ssh.ExitError{}
+	if errors.As(err, &sshExitErr) {
+		if sshExitErr.Signal() == "" {
+			return sshExitErr.ExitStatus()
 		}
 
-		log.Default.Fatal(err)
+		log.Default.ErrorStreamOnly().Errorf(
+			"remote command killed by signal %s",
+			sshExitErr.Signal(),
+		)
+		return 255
 	}
+
+	exitMissingErr := &ssh.ExitMissingError{}
+	if errors.As(err, &exitMissingErr) {
+		log.Default.ErrorStreamOnly().Error(exitMissingErr.Error())
+		return 255
+	}
+
+	exitErr := &exec.ExitError{}
+	if errors.As(err, &exitErr) {
+		if len(exitErr.Stderr) > 0 {
+			log.Default.ErrorStreamOnly().Error(string(exitErr.Stderr))
+		}
+		if exitErr.ExitCode() < 0 {
+			log.Default.ErrorStreamOnly().Errorf("command exited with %s", exitErr)
+			return 255
+		}
+		return exitErr.ExitCode()
+	}
+
+	log.Default.Fatal(err)
+	return 1
 }
 
 // BuildRoot creates a new root command from the
@@ -55,5 +107,13 @@ func BuildRoot() *cobra.Command {
 
 	rootCmd.AddCommand(NewInitCmd())
 	rootCmd.AddCommand(NewCommandCmd())
+	rootCmd.AddCommand(NewStatusCmd())
+	rootCmd.AddCommand(NewCreateCmd())
+	rootCmd.AddCommand(NewDeleteCmd())
+	rootCmd.AddCommand(NewStartCmd())
+	rootCmd.AddCommand(NewStopCmd())
+	rootCmd.AddCommand(NewDoctorCmd())
+	rootCmd.AddCommand(NewForwardCmd())
+	rootCmd.AddCommand(NewProxyCmd())
 	return rootCmd
 }
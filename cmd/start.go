@@ -0,0 +1,48 @@
+package cmd
+
+import (
+	"context"
+
+	"github.com/skevetter/devpod-provider-ssh/pkg/ssh"
+	"github.com/skevetter/log"
+	"github.com/spf13/cobra"
+)
+
+// StartCmd holds the cmd flags
+type StartCmd struct{}
+
+// NewStartCmd defines a start
+func NewStartCmd() *cobra.Command {
+	cmd := &StartCmd{}
+	startCmd := &cobra.Command{
+		Use:   "start",
+		Short: "Wake the host with Wake-on-LAN if MAC_ADDRESS is set",
+		RunE: func(_ *cobra.Command, args []string) error {
+			sshProvider, err := ssh.NewProvider(log.Default)
+			if err != nil {
+				return err
+			}
+			defer func() { _ = sshProvider.Close() }()
+
+			ctx, stop := signalContext()
+			defer stop()
+
+			return cmd.Run(
+				ctx,
+				sshProvider,
+				log.Default,
+			)
+		},
+	}
+
+	return startCmd
+}
+
+// Run runs the start logic
+func (cmd *StartCmd) Run(
+	ctx context.Context,
+	providerSSH *ssh.SSHProvider,
+	logs log.Logger,
+) error {
+	return ssh.Start(ctx, providerSSH)
+}
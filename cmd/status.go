@@ -0,0 +1,55 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/skevetter/devpod-provider-ssh/pkg/ssh"
+	"github.com/skevetter/log"
+	"github.com/spf13/cobra"
+)
+
+// StatusCmd holds the cmd flags
+type StatusCmd struct{}
+
+// NewStatusCmd defines a status
+func NewStatusCmd() *cobra.Command {
+	cmd := &StatusCmd{}
+	statusCmd := &cobra.Command{
+		Use:   "status",
+		Short: "Status of an instance",
+		RunE: func(_ *cobra.Command, args []string) error {
+			sshProvider, err := ssh.NewProvider(log.Default)
+			if err != nil {
+				return err
+			}
+			defer func() { _ = sshProvider.Close() }()
+
+			ctx, stop := signalContext()
+			defer stop()
+
+			return cmd.Run(
+				ctx,
+				sshProvider,
+				log.Default,
+			)
+		},
+	}
+
+	return statusCmd
+}
+
+// Run runs the status logic
+func (cmd *StatusCmd) Run(
+	ctx context.Context,
+	providerSSH *ssh.SSHProvider,
+	logs log.Logger,
+) error {
+	status, err := ssh.Status(ctx, providerSSH)
+	if err != nil {
+		return err
+	}
+
+	_, err = fmt.Println(status)
+	return err
+}
@@ -0,0 +1,48 @@
+package cmd
+
+import (
+	"context"
+
+	"github.com/skevetter/devpod-provider-ssh/pkg/ssh"
+	"github.com/skevetter/log"
+	"github.com/spf13/cobra"
+)
+
+// StopCmd holds the cmd flags
+type StopCmd struct{}
+
+// NewStopCmd defines a stop
+func NewStopCmd() *cobra.Command {
+	cmd := &StopCmd{}
+	stopCmd := &cobra.Command{
+		Use:   "stop",
+		Short: "Suspend or power off the host with STOP_COMMAND if it is set",
+		RunE: func(_ *cobra.Command, args []string) error {
+			sshProvider, err := ssh.NewProvider(log.Default)
+			if err != nil {
+				return err
+			}
+			defer func() { _ = sshProvider.Close() }()
+
+			ctx, stop := signalContext()
+			defer stop()
+
+			return cmd.Run(
+				ctx,
+				sshProvider,
+				log.Default,
+			)
+		},
+	}
+
+	return stopCmd
+}
+
+// Run runs the stop logic
+func (cmd *StopCmd) Run(
+	ctx context.Context,
+	providerSSH *ssh.SSHProvider,
+	logs log.Logger,
+) error {
+	return ssh.Stop(ctx, providerSSH)
+}
@@ -0,0 +1,108 @@
+package options
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// Host is a HOST split into its parts. User and Port are empty if HOST has
+// none.
+type Host struct {
+	User     string
+	Hostname string
+	Port     string
+}
+
+// ParseHost splits a HOST of the form [user@]host[:port] or
+// ssh://[user@]host[:port] into its parts. IPv6 addresses have to be in
+// brackets if they have a port, in ssh:// URIs their zone is written as %25.
+func ParseHost(value string) (Host, error) {
+	value = strings.TrimSpace(value)
+	rest, isURI := strings.CutPrefix(value, "ssh://")
+	if isURI {
+		rest = strings.TrimSuffix(rest, "/")
+		if strings.Contains(rest, "/") {
+			return Host{}, fmt.Errorf("ssh URI %q must not have a path", value)
+		}
+	}
+
+	host := Host{}
+	if i := strings.LastIndex(rest, "@"); i >= 0 {
+		host.User, rest = rest[:i], rest[i+1:]
+		if host.User == "" {
+			return Host{}, fmt.Errorf("empty user in %q", value)
+		}
+	}
+
+	var err error
+	host.Hostname, host.Port, err = splitHostPort(rest)
+	if err != nil {
+		return Host{}, fmt.Errorf("%w in %q", err, value)
+	}
+
+	if isURI {
+		host.User, err = url.PathUnescape(host.User)
+		if err != nil {
+			return Host{}, fmt.Errorf("invalid user in %q: %w", value, err)
+		}
+		host.Hostname, err = url.PathUnescape(host.Hostname)
+		if err != nil {
+			return Host{}, fmt.Errorf("invalid host in %q: %w", value, err)
+		}
+	}
+
+	if host.Hostname == "" {
+		return Host{}, fmt.Errorf("empty host in %q", value)
+	}
+	if host.Port != "" {
+		err = checkPort(host.Port)
+		if err != nil {
+			return Host{}, fmt.Errorf("invalid port in %q: %w", value, err)
+		}
+	}
+
+	return host, nil
+}
+
+// splitHostPort splits host[:port] or [host]:port. An address with more
+// than one colon and no brackets is an IPv6 address without port.
+func splitHostPort(value string) (string, string, error) {
+	if !strings.HasPrefix(value, "[") {
+		if strings.Count(value, ":") != 1 {
+			return value, "", nil
+		}
+		host, port, _ := strings.Cut(value, ":")
+		if port == "" {
+			return "", "", errors.New("empty port")
+		}
+		return host, port, nil
+	}
+
+	end := strings.Index(value, "]")
+	if end < 0 {
+		return "", "", errors.New("missing ]")
+	}
+
+	after := value[end+1:]
+	port, found := strings.CutPrefix(after, ":")
+	if after != "" && !found {
+		return "", "", fmt.Errorf("unexpected %q after ]", after)
+	}
+	if found && port == "" {
+		return "", "", errors.New("empty port")
+	}
+
+	return value[1:end], port, nil
+}
+
+func checkPort(port string) error {
+	number, err := strconv.Atoi(port)
+	if err != nil || number < 1 || number > 65535 {
+		return errors.New("expected a number between 1 and 65535")
+	}
+
+	return nil
+}
@@ -0,0 +1,105 @@
+package options
+
+import "testing"
+
+func TestParseHost(t *testing.T) {
+	tests := []struct {
+		value string
+		want  Host
+		err   bool
+	}{
+		{value: "host", want: Host{Hostname: "host"}},
+		{value: "user@host", want: Host{User: "user", Hostname: "host"}},
+		{value: "user@host:2222", want: Host{User: "user", Hostname: "host", Port: "2222"}},
+		{value: " user@host ", want: Host{User: "user", Hostname: "host"}},
+		{value: "user@domain@host", want: Host{User: "user@domain", Hostname: "host"}},
+		{value: "2001:db8::1", want: Host{Hostname: "2001:db8::1"}},
+		{value: "[2001:db8::1]", want: Host{Hostname: "2001:db8::1"}},
+		{value: "user@[2001:db8::1]:2222", want: Host{User: "user", Hostname: "2001:db8::1", Port: "2222"}},
+		{value: "user@[fe80::1%eth0]:2222", want: Host{User: "user", Hostname: "fe80::1%eth0", Port: "2222"}},
+		{value: "ssh://host", want: Host{Hostname: "host"}},
+		{value: "ssh://user@host:2200", want: Host{User: "user", Hostname: "host", Port: "2200"}},
+		{value: "ssh://user@host:2200/", want: Host{User: "user", Hostname: "host", Port: "2200"}},
+		{value: "ssh://user@[fe80::1%25eth0]:2200", want: Host{User: "user", Hostname: "fe80::1%eth0", Port: "2200"}},
+		{value: "ssh://first%20last@host", want: Host{User: "first last", Hostname: "host"}},
+		{value: "", err: true},
+		{value: "user@", err: true},
+		{value: "@host", err: true},
+		{value: "host:", err: true},
+		{value: "host:ssh", err: true},
+		{value: "host:70000", err: true},
+		{value: "[2001:db8::1]:", err: true},
+		{value: "[2001:db8::1", err: true},
+		{value: "[2001:db8::1]2222", err: true},
+		{value: "ssh://host/path", err: true},
+		{value: "ssh://user@[fe80::1%eth0]", err: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.value, func(t *testing.T) {
+			got, err := ParseHost(test.value)
+			if test.err {
+				if err == nil {
+					t.Errorf("expected an error, got %+v", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if got != test.want {
+				t.Errorf("got %+v, want %+v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestHostFromEnv(t *testing.T) {
+	tests := []struct {
+		host string
+		port string
+		want string
+		err  bool
+	}{
+		{host: "user@host", port: "22", want: "22"},
+		{host: "user@host", port: "2200", want: "2200"},
+		{host: "user@host:2222", port: "22", want: "2222"},
+		{host: "user@host:2222", port: "", want: "2222"},
+		{host: "user@host:2222", port: "2222", want: "2222"},
+		{host: "ssh://user@host:2200", port: "2200", want: "2200"},
+		{host: "user@host:2222", port: "2200", err: true},
+		{host: "ssh://user@host:2200", port: "2222", err: true},
+		{host: "user@host", port: "", err: true},
+		{host: "user@host", port: "ssh", err: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.host+" "+test.port, func(t *testing.T) {
+			t.Setenv(HOST, test.host)
+			t.Setenv(PORT, test.port)
+
+			options := &Options{}
+			err := hostFromEnv(options)
+			if test.err {
+				if err == nil {
+					t.Errorf("expected an error, got port %s", options.Port)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if options.User != "user" || options.Host != "host" || options.Port != test.want {
+				t.Errorf(
+					"got %s@%s port %s, want user@host port %s",
+					options.User,
+					options.Host,
+					options.Port,
+					test.want,
+				)
+			}
+		})
+	}
+}
@@ -2,26 +2,115 @@ package options
 
 import (
 	"fmt"
+	"net"
+	"net/url"
 	"os"
+	"path"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
 )
 
 var (
-	DOCKER_PATH     = "DOCKER_PATH"
-	AGENT_PATH      = "AGENT_PATH"
-	HOST            = "HOST"
-	PORT            = "PORT"
-	EXTRA_FLAGS     = "EXTRA_FLAGS"
-	USE_BUILTIN_SSH = "USE_BUILTIN_SSH"
+	DOCKER_PATH              = "DOCKER_PATH"
+	AGENT_PATH               = "AGENT_PATH"
+	HOST                     = "HOST"
+	PORT                     = "PORT"
+	EXTRA_FLAGS              = "EXTRA_FLAGS"
+	USE_BUILTIN_SSH          = "USE_BUILTIN_SSH"
+	STRICT_HOST_KEY_CHECKING = "STRICT_HOST_KEY_CHECKING"
+	KNOWN_HOSTS_FILE         = "KNOWN_HOSTS_FILE"
+	HOST_KEY_FINGERPRINT     = "HOST_KEY_FINGERPRINT"
+	KEY_PASSPHRASE           = "KEY_PASSPHRASE"
+	KEY_PASSPHRASE_COMMAND   = "KEY_PASSPHRASE_COMMAND"
+	CERTIFICATE_FILE         = "CERTIFICATE_FILE"
+	JUMP_HOSTS               = "JUMP_HOSTS"
+	CONTROL_PERSIST          = "CONTROL_PERSIST"
+	SERVER_ALIVE_INTERVAL    = "SERVER_ALIVE_INTERVAL"
+	SERVER_ALIVE_COUNT_MAX   = "SERVER_ALIVE_COUNT_MAX"
+	CONNECT_TIMEOUT          = "CONNECT_TIMEOUT"
+	CONNECT_RETRIES          = "CONNECT_RETRIES"
+	CONNECT_BACKOFF          = "CONNECT_BACKOFF"
+	REQUEST_TTY              = "REQUEST_TTY"
+	FORWARD_AGENT            = "FORWARD_AGENT"
+	ALLOW_INTERACTIVE_AUTH   = "ALLOW_INTERACTIVE_AUTH"
+	PASSWORD_COMMAND         = "PASSWORD_COMMAND"
+	PROXY_URL                = "PROXY_URL"
+	CIPHERS                  = "CIPHERS"
+	KEX_ALGORITHMS           = "KEX_ALGORITHMS"
+	MACS                     = "MACS"
+	HOST_KEY_ALGORITHMS      = "HOST_KEY_ALGORITHMS"
+	FORWARD_ENV              = "FORWARD_ENV"
+	TRANSPORT                = "TRANSPORT"
+	LOCK_FILE                = "LOCK_FILE"
+	KEEP_CONTAINERS          = "KEEP_CONTAINERS"
+	MAC_ADDRESS              = "MAC_ADDRESS"
+	WAKE_ON_LAN_ADDRESS      = "WAKE_ON_LAN_ADDRESS"
+	START_TIMEOUT            = "START_TIMEOUT"
+	STOP_COMMAND             = "STOP_COMMAND"
+	MACHINE_ID               = "MACHINE_ID"
+	WORKSPACE_ID             = "WORKSPACE_ID"
+	WORKSPACE_UID            = "WORKSPACE_UID"
+)
+
+const (
+	StrictHostKeyCheckingYes       = "yes"
+	StrictHostKeyCheckingAcceptNew = "accept-new"
+	StrictHostKeyCheckingNo        = "no"
+
+	RequestTTYAuto = "auto"
+	RequestTTYYes  = "yes"
+	RequestTTYNo   = "no"
+
+	TransportSSH   = "ssh"
+	TransportLocal = "local"
+	TransportAuto  = "auto"
 )
 
 type Options struct {
-	DockerPath    string
-	AgentPath     string
-	User          string
-	Host          string
-	Port          string
-	ExtraFlags    string
-	UseBuiltinSSH bool
+	DockerPath            string
+	AgentPath             string
+	User                  string
+	Host                  string
+	Port                  string
+	ExtraFlags            string
+	UseBuiltinSSH         bool
+	StrictHostKeyChecking string
+	KnownHostsFile        string
+	HostKeyFingerprint    string
+	KeyPassphrase         string
+	KeyPassphraseCommand  string
+	CertificateFile       string
+	JumpHosts             string
+	ControlPersist        string
+	ServerAliveInterval   string
+	ServerAliveCountMax   string
+	ConnectTimeout        string
+	ConnectRetries        int
+	ConnectBackoff        time.Duration
+	RequestTTY            string
+	ForwardAgent          bool
+	AllowInteractiveAuth  bool
+	PasswordCommand       string
+	ProxyURL              *url.URL
+	NoProxy               string
+	Ciphers               string
+	KexAlgorithms         string
+	MACs                  string
+	HostKeyAlgorithms     string
+	ForwardEnv            []string
+	Transport             string
+	LockFile              string
+	KeepContainers        bool
+	MACAddress            net.HardwareAddr
+	WakeOnLANAddress      string
+	StartTimeout          time.Duration
+	StopCommand           string
+	MachineID             string
+	WorkspaceID           string
+	WorkspaceUID          string
 }
 
 func FromEnv() (*Options, error) {
@@ -40,13 +129,57 @@ func FromEnv() (*Options, error) {
 	}
 
 	retOptions.ExtraFlags = os.Getenv(EXTRA_FLAGS)
+	retOptions.LockFile = os.Getenv(LOCK_FILE)
+	retOptions.KeepContainers = os.Getenv(KEEP_CONTAINERS) == "true"
+	retOptions.MachineID = os.Getenv(MACHINE_ID)
+	retOptions.WorkspaceID = os.Getenv(WORKSPACE_ID)
+	retOptions.WorkspaceUID = os.Getenv(WORKSPACE_UID)
+	retOptions.JumpHosts = os.Getenv(JUMP_HOSTS)
+	retOptions.ControlPersist = fromEnvOrDefault(CONTROL_PERSIST, "10m")
+
+	err = wakeOnLANFromEnv(retOptions)
+	if err != nil {
+		return nil, err
+	}
+
+	err = keepAliveFromEnv(retOptions)
+	if err != nil {
+		return nil, err
+	}
+
+	err = connectFromEnv(retOptions)
+	if err != nil {
+		return nil, err
+	}
+
+	retOptions.Ciphers = os.Getenv(CIPHERS)
+	retOptions.KexAlgorithms = os.Getenv(KEX_ALGORITHMS)
+	retOptions.MACs = os.Getenv(MACS)
+
+	retOptions.RequestTTY, err = fromEnvOneOf(
+		REQUEST_TTY,
+		RequestTTYAuto,
+		RequestTTYAuto, RequestTTYYes, RequestTTYNo,
+	)
+	if err != nil {
+		return nil, err
+	}
 
-	retOptions.Host, err = fromEnvOrError(HOST)
+	err = hostFromEnv(retOptions)
 	if err != nil {
 		return nil, err
 	}
 
-	retOptions.Port, err = fromEnvOrError(PORT)
+	retOptions.Transport, err = fromEnvOneOf(
+		TRANSPORT,
+		TransportSSH,
+		TransportSSH, TransportLocal, TransportAuto,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	err = proxyFromEnv(retOptions)
 	if err != nil {
 		return nil, err
 	}
@@ -56,10 +189,245 @@ func FromEnv() (*Options, error) {
 		return nil, err
 	}
 	retOptions.UseBuiltinSSH = builtinSSH == "true"
+	retOptions.ForwardAgent = os.Getenv(FORWARD_AGENT) == "true"
+
+	retOptions.ForwardEnv, err = forwardEnvFromEnv()
+	if err != nil {
+		return nil, err
+	}
+
+	err = hostKeyFromEnv(retOptions)
+	if err != nil {
+		return nil, err
+	}
+
+	retOptions.KeyPassphrase = os.Getenv(KEY_PASSPHRASE)
+	retOptions.KeyPassphraseCommand = os.Getenv(KEY_PASSPHRASE_COMMAND)
+	retOptions.CertificateFile = os.Getenv(CERTIFICATE_FILE)
+	retOptions.AllowInteractiveAuth = os.Getenv(ALLOW_INTERACTIVE_AUTH) == "true"
+	retOptions.PasswordCommand = os.Getenv(PASSWORD_COMMAND)
 
 	return retOptions, nil
 }
 
+// hostFromEnv splits HOST into user, host and port. A port in HOST is used
+// unless PORT is set to a different port than the default.
+func hostFromEnv(retOptions *Options) error {
+	host, err := fromEnvOrError(HOST)
+	if err != nil {
+		return err
+	}
+
+	parsed, err := ParseHost(host)
+	if err != nil {
+		return fmt.Errorf("invalid value for %s: %w", HOST, err)
+	}
+	retOptions.User, retOptions.Host = parsed.User, parsed.Hostname
+	hostPort := parsed.Port
+
+	port := os.Getenv(PORT)
+	switch {
+	case hostPort == "":
+		port, err = fromEnvOrError(PORT)
+		if err != nil {
+			return err
+		}
+	case port == "" || port == "22":
+		port = hostPort
+	case port != hostPort:
+		return fmt.Errorf(
+			"%s has port %s but %s is %s, set the port only once",
+			HOST,
+			hostPort,
+			PORT,
+			port,
+		)
+	}
+
+	err = checkPort(port)
+	if err != nil {
+		return fmt.Errorf("invalid value %q for %s: %w", port, PORT, err)
+	}
+	retOptions.Port = port
+
+	return nil
+}
+
+// proxyFromEnv reads PROXY_URL, falling back to ALL_PROXY like curl does.
+// Hosts in NO_PROXY are connected to directly.
+func proxyFromEnv(retOptions *Options) error {
+	name, value := PROXY_URL, os.Getenv(PROXY_URL)
+	for _, fallback := range []string{"ALL_PROXY", "all_proxy"} {
+		if value == "" {
+			name, value = fallback, os.Getenv(fallback)
+		}
+	}
+	if value == "" {
+		return nil
+	}
+
+	proxyURL, err := ParseProxyURL(value)
+	if err != nil {
+		return fmt.Errorf("invalid value for %s: %w", name, err)
+	}
+	retOptions.ProxyURL = proxyURL
+
+	retOptions.NoProxy = os.Getenv("NO_PROXY")
+	if retOptions.NoProxy == "" {
+		retOptions.NoProxy = os.Getenv("no_proxy")
+	}
+
+	return nil
+}
+
+// forwardEnvFromEnv splits FORWARD_ENV into its names and glob patterns.
+func forwardEnvFromEnv() ([]string, error) {
+	patterns := strings.FieldsFunc(os.Getenv(FORWARD_ENV), func(r rune) bool {
+		return r == ',' || unicode.IsSpace(r)
+	})
+	for _, pattern := range patterns {
+		_, err := path.Match(pattern, "")
+		if err != nil || strings.Contains(pattern, "=") {
+			return nil, fmt.Errorf(
+				"invalid value for %s: %q is no variable name or glob pattern",
+				FORWARD_ENV,
+				pattern,
+			)
+		}
+	}
+
+	return patterns, nil
+}
+
+func hostKeyFromEnv(retOptions *Options) error {
+	retOptions.StrictHostKeyChecking = fromEnvOrDefault(
+		STRICT_HOST_KEY_CHECKING,
+		StrictHostKeyCheckingNo,
+	)
+	switch retOptions.StrictHostKeyChecking {
+	case StrictHostKeyCheckingYes, StrictHostKeyCheckingAcceptNew, StrictHostKeyCheckingNo:
+	default:
+		return fmt.Errorf(
+			"invalid value %q for %s, expected one of yes, accept-new or no",
+			retOptions.StrictHostKeyChecking,
+			STRICT_HOST_KEY_CHECKING,
+		)
+	}
+
+	retOptions.KnownHostsFile = os.Getenv(KNOWN_HOSTS_FILE)
+	retOptions.HostKeyFingerprint = os.Getenv(HOST_KEY_FINGERPRINT)
+	retOptions.HostKeyAlgorithms = os.Getenv(HOST_KEY_ALGORITHMS)
+
+	return nil
+}
+
+func keepAliveFromEnv(retOptions *Options) error {
+	retOptions.ServerAliveInterval = os.Getenv(SERVER_ALIVE_INTERVAL)
+	if retOptions.ServerAliveInterval != "" {
+		_, err := ParseSeconds(retOptions.ServerAliveInterval)
+		if err != nil {
+			return fmt.Errorf("invalid value for %s: %w", SERVER_ALIVE_INTERVAL, err)
+		}
+	}
+
+	retOptions.ServerAliveCountMax = os.Getenv(SERVER_ALIVE_COUNT_MAX)
+	if retOptions.ServerAliveCountMax != "" {
+		count, err := strconv.Atoi(retOptions.ServerAliveCountMax)
+		if err != nil || count < 0 {
+			return fmt.Errorf(
+				"invalid value %q for %s, expected zero or a positive number",
+				retOptions.ServerAliveCountMax,
+				SERVER_ALIVE_COUNT_MAX,
+			)
+		}
+	}
+
+	return nil
+}
+
+func connectFromEnv(retOptions *Options) error {
+	retOptions.ConnectTimeout = os.Getenv(CONNECT_TIMEOUT)
+	if retOptions.ConnectTimeout != "" {
+		_, err := ParseSeconds(retOptions.ConnectTimeout)
+		if err != nil {
+			return fmt.Errorf("invalid value for %s: %w", CONNECT_TIMEOUT, err)
+		}
+	}
+
+	retries := fromEnvOrDefault(CONNECT_RETRIES, "3")
+	count, err := strconv.Atoi(retries)
+	if err != nil || count < 0 {
+		return fmt.Errorf(
+			"invalid value %q for %s, expected zero or a positive number",
+			retries,
+			CONNECT_RETRIES,
+		)
+	}
+	retOptions.ConnectRetries = count
+
+	retOptions.ConnectBackoff, err = ParseSeconds(fromEnvOrDefault(CONNECT_BACKOFF, "1s"))
+	if err != nil {
+		return fmt.Errorf("invalid value for %s: %w", CONNECT_BACKOFF, err)
+	}
+
+	return nil
+}
+
+// wakeOnLANFromEnv reads the options of the start and stop commands. The
+// magic packet is broadcast on port 9 unless WAKE_ON_LAN_ADDRESS has a port.
+func wakeOnLANFromEnv(retOptions *Options) error {
+	var err error
+	if mac := os.Getenv(MAC_ADDRESS); mac != "" {
+		retOptions.MACAddress, err = net.ParseMAC(mac)
+		if err != nil || len(retOptions.MACAddress) != 6 {
+			return fmt.Errorf(
+				"invalid value %q for %s, expected a MAC address like 00:11:22:33:44:55",
+				mac,
+				MAC_ADDRESS,
+			)
+		}
+	}
+
+	address := fromEnvOrDefault(WAKE_ON_LAN_ADDRESS, "255.255.255.255")
+	host, port, err := net.SplitHostPort(address)
+	if err != nil {
+		host, port = strings.Trim(address, "[]"), "9"
+	}
+	err = checkPort(port)
+	if err != nil {
+		return fmt.Errorf("invalid value %q for %s: %w", address, WAKE_ON_LAN_ADDRESS, err)
+	}
+	retOptions.WakeOnLANAddress = net.JoinHostPort(host, port)
+
+	retOptions.StartTimeout, err = ParseSeconds(fromEnvOrDefault(START_TIMEOUT, "5m"))
+	if err != nil {
+		return fmt.Errorf("invalid value for %s: %w", START_TIMEOUT, err)
+	}
+
+	retOptions.StopCommand = os.Getenv(STOP_COMMAND)
+
+	return nil
+}
+
+// ParseSeconds parses a time the way ssh_config does, either a number of
+// seconds or a duration like 1m30s.
+func ParseSeconds(value string) (time.Duration, error) {
+	seconds, err := strconv.Atoi(value)
+	if err == nil {
+		if seconds < 0 {
+			return 0, fmt.Errorf("negative time %q", value)
+		}
+		return time.Duration(seconds) * time.Second, nil
+	}
+
+	duration, err := time.ParseDuration(value)
+	if err != nil || duration < 0 {
+		return 0, fmt.Errorf("expected seconds or a duration like 1m30s, got %q", value)
+	}
+
+	return duration, nil
+}
+
 func fromEnvOrError(name string) (string, error) {
 	val := os.Getenv(name)
 	if val == "" {
@@ -72,3 +440,26 @@ func fromEnvOrError(name string) (string, error) {
 
 	return val, nil
 }
+
+func fromEnvOneOf(name string, defaultValue string, allowed ...string) (string, error) {
+	val := fromEnvOrDefault(name, defaultValue)
+	if !slices.Contains(allowed, val) {
+		return "", fmt.Errorf(
+			"invalid value %q for %s, expected one of %s",
+			val,
+			name,
+			strings.Join(allowed, ", "),
+		)
+	}
+
+	return val, nil
+}
+
+func fromEnvOrDefault(name string, defaultValue string) string {
+	val := os.Getenv(name)
+	if val == "" {
+		return defaultValue
+	}
+
+	return val
+}
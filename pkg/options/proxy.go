@@ -0,0 +1,62 @@
+package options
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+)
+
+// proxyPorts are the default ports of the supported proxy schemes.
+var proxyPorts = map[string]string{
+	"socks5":  "1080",
+	"socks5h": "1080",
+	"http":    "80",
+	"https":   "443",
+	"ssh":     "22",
+}
+
+// ParseProxyURL parses a proxy URL of the form
+// scheme://[user[:password]@]host[:port]. socks5 resolves the target host
+// locally, socks5h lets the proxy resolve it, http and https tunnel the
+// connection with CONNECT and ssh connects through the host as a jump host.
+// The port defaults to the usual one of the scheme. Errors never contain the
+// password.
+func ParseProxyURL(value string) (*url.URL, error) {
+	proxyURL, err := url.Parse(value)
+	if err != nil {
+		// the error of url.Parse contains the whole value
+		return nil, errors.New("expected scheme://[user[:password]@]host[:port]")
+	}
+
+	defaultPort, ok := proxyPorts[proxyURL.Scheme]
+	if !ok {
+		return nil, fmt.Errorf(
+			"unsupported proxy scheme %q, expected socks5, socks5h, http, https or ssh",
+			proxyURL.Scheme,
+		)
+	}
+	if proxyURL.Hostname() == "" {
+		return nil, fmt.Errorf("empty host in %q", proxyURL.Redacted())
+	}
+	if _, ok := proxyURL.User.Password(); ok && proxyURL.Scheme == "ssh" {
+		return nil, fmt.Errorf("ssh proxy URL %q must not have a password", proxyURL.Redacted())
+	}
+	if (proxyURL.Path != "" && proxyURL.Path != "/") || proxyURL.RawQuery != "" {
+		return nil, fmt.Errorf("proxy URL %q must not have a path", proxyURL.Redacted())
+	}
+
+	port := proxyURL.Port()
+	if port == "" {
+		port = defaultPort
+	}
+	err = checkPort(port)
+	if err != nil {
+		return nil, fmt.Errorf("invalid port in %q: %w", proxyURL.Redacted(), err)
+	}
+
+	proxyURL.Host = net.JoinHostPort(proxyURL.Hostname(), port)
+	proxyURL.Path = ""
+
+	return proxyURL, nil
+}
@@ -0,0 +1,140 @@
+package ssh
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strings"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+)
+
+// agentChannelType is the channel the host opens to reach a forwarded agent.
+const agentChannelType = "auth-agent@openssh.com"
+
+// agentSocket returns the agent socket configured by IdentityAgent, falling
+// back to SSH_AUTH_SOCK. An empty string means no agent should be used.
+func agentSocket(config *hostConfig) (string, error) {
+	return expandAgentSocket(config.IdentityAgent)
+}
+
+// expandAgentSocket expands an agent socket the way IdentityAgent does.
+func expandAgentSocket(socket string) (string, error) {
+	switch {
+	case socket == "none":
+		return "", nil
+	case socket == "" || socket == "SSH_AUTH_SOCK":
+		return os.Getenv("SSH_AUTH_SOCK"), nil
+	case strings.HasPrefix(socket, "$"):
+		return os.Getenv(strings.TrimPrefix(socket, "$")), nil
+	}
+
+	socket = os.ExpandEnv(socket)
+	if strings.HasPrefix(socket, "~") {
+		return expandPath(socket)
+	}
+
+	return socket, nil
+}
+
+// agentSigners returns the signers of the ssh agent together with the
+// connection to the agent. Both are nil if no agent is available.
+func agentSigners(provider *SSHProvider, config *hostConfig) ([]ssh.Signer, net.Conn, error) {
+	socket, err := agentSocket(config)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	conn, err := dialAgent(socket)
+	if err != nil {
+		if socket != "" {
+			provider.Log.Debugf("connect to ssh agent %s: %v", socket, err)
+		}
+		return nil, nil, nil
+	}
+
+	signers, err := agent.NewClient(conn).Signers()
+	if err != nil {
+		_ = conn.Close()
+		return nil, nil, fmt.Errorf("list ssh agent keys: %w", err)
+	}
+
+	return signers, conn, nil
+}
+
+// forwardAgentSocket returns the agent socket to forward to the host, from
+// FORWARD_AGENT or the ForwardAgent directive. ssh forwards the agent it
+// authenticates with, a path or variable forwards that agent instead. An
+// empty string means the agent isn't forwarded.
+func forwardAgentSocket(provider *SSHProvider, config *hostConfig) (string, error) {
+	forward := config.ForwardAgent
+	if provider.Config.ForwardAgent {
+		forward = "yes"
+	}
+
+	switch forward {
+	case "", "no":
+		return "", nil
+	case "yes":
+		socket, err := agentSocket(config)
+		if err != nil || socket != "" {
+			return socket, err
+		}
+		// IdentityAgent none only disables the agent for authentication
+		return os.Getenv("SSH_AUTH_SOCK"), nil
+	}
+
+	return expandAgentSocket(forward)
+}
+
+// forwardAgent proxies the agent channels the host opens on client to the
+// local agent, if agent forwarding is enabled. It returns whether it is.
+func forwardAgent(provider *SSHProvider, client *ssh.Client, config *hostConfig) (bool, error) {
+	socket, err := forwardAgentSocket(provider, config)
+	if err != nil {
+		return false, err
+	}
+	if socket == "" {
+		if provider.Config.ForwardAgent {
+			provider.Log.Warnf("No ssh agent found, the agent is not forwarded")
+		}
+		return false, nil
+	}
+
+	channels := client.HandleChannelOpen(agentChannelType)
+	if channels == nil {
+		return false, fmt.Errorf("forward agent: %s channels are already handled", agentChannelType)
+	}
+
+	go func() {
+		for newChannel := range channels {
+			channel, requests, err := newChannel.Accept()
+			if err != nil {
+				continue
+			}
+			go ssh.DiscardRequests(requests)
+			go proxyAgent(provider, channel, socket)
+		}
+	}()
+
+	return true, nil
+}
+
+func proxyAgent(provider *SSHProvider, channel ssh.Channel, socket string) {
+	defer func() { _ = channel.Close() }()
+
+	conn, err := dialAgent(socket)
+	if err != nil {
+		provider.Log.Debugf("forward agent, connect to ssh agent %s: %v", socket, err)
+		return
+	}
+	defer func() { _ = conn.Close() }()
+
+	go func() {
+		_, _ = io.Copy(conn, channel)
+		_ = conn.Close()
+	}()
+	_, _ = io.Copy(channel, conn)
+}
@@ -0,0 +1,16 @@
+//go:build !windows
+
+package ssh
+
+import (
+	"errors"
+	"net"
+)
+
+func dialAgent(socket string) (net.Conn, error) {
+	if socket == "" {
+		return nil, errors.New("no ssh agent socket")
+	}
+
+	return net.Dial("unix", socket)
+}
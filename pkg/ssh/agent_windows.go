@@ -0,0 +1,25 @@
+//go:build windows
+
+package ssh
+
+import (
+	"net"
+	"strings"
+
+	"github.com/Microsoft/go-winio"
+)
+
+// defaultAgentPipe is the named pipe of the Windows OpenSSH agent service.
+const defaultAgentPipe = `\\.\pipe\openssh-ssh-agent`
+
+func dialAgent(socket string) (net.Conn, error) {
+	if socket == "" {
+		socket = defaultAgentPipe
+	}
+
+	if strings.HasPrefix(socket, `\\.\pipe\`) {
+		return winio.DialPipe(socket, nil)
+	}
+
+	return net.Dial("unix", socket)
+}
@@ -0,0 +1,154 @@
+package ssh
+
+import (
+	"fmt"
+	"path"
+	"slices"
+	"strings"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// cryptoConfig returns the ciphers, key exchanges and MACs of config for the
+// builtin client. Lists that aren't set keep the defaults of the client.
+func cryptoConfig(config *hostConfig) (ssh.Config, error) {
+	supported := ssh.SupportedAlgorithms()
+	insecure := ssh.InsecureAlgorithms()
+
+	ciphers, err := algorithmList(
+		"Ciphers",
+		config.Ciphers,
+		supported.Ciphers,
+		insecure.Ciphers,
+	)
+	if err != nil {
+		return ssh.Config{}, err
+	}
+
+	keyExchanges, err := algorithmList(
+		"KexAlgorithms",
+		config.KexAlgorithms,
+		supported.KeyExchanges,
+		insecure.KeyExchanges,
+	)
+	if err != nil {
+		return ssh.Config{}, err
+	}
+
+	macs, err := algorithmList(
+		"MACs",
+		config.MACs,
+		supported.MACs,
+		insecure.MACs,
+	)
+	if err != nil {
+		return ssh.Config{}, err
+	}
+
+	return ssh.Config{
+		Ciphers:      ciphers,
+		KeyExchanges: keyExchanges,
+		MACs:         macs,
+	}, nil
+}
+
+// configHostKeyAlgorithms returns the HostKeyAlgorithms of config, or nil for
+// the defaults of the builtin client.
+func configHostKeyAlgorithms(config *hostConfig) ([]string, error) {
+	return algorithmList(
+		"HostKeyAlgorithms",
+		config.HostKeyAlgorithms,
+		ssh.SupportedAlgorithms().HostKeys,
+		ssh.InsecureAlgorithms().HostKeys,
+	)
+}
+
+// algorithmList applies an algorithm list of the ssh config to defaults. Like
+// in ssh_config a list starting with + is appended to the defaults, one
+// starting with - removes the algorithms matching its patterns and one
+// starting with ^ is put in front of them. Algorithms the builtin client
+// doesn't implement are skipped, insecure ones have to be enabled explicitly.
+func algorithmList(
+	directive string,
+	spec string,
+	defaults []string,
+	insecure []string,
+) ([]string, error) {
+	if spec == "" {
+		return nil, nil
+	}
+
+	var result []string
+	switch spec[0] {
+	case '+':
+		result = appendAlgorithms(slices.Clone(defaults), splitAlgorithms(spec[1:]))
+	case '-':
+		patterns := splitAlgorithms(spec[1:])
+		result = slices.DeleteFunc(slices.Clone(defaults), func(algorithm string) bool {
+			return slices.ContainsFunc(patterns, func(pattern string) bool {
+				matched, _ := path.Match(pattern, algorithm)
+				return matched
+			})
+		})
+	case '^':
+		result = appendAlgorithms(splitAlgorithms(spec[1:]), defaults)
+	default:
+		result = splitAlgorithms(spec)
+	}
+
+	result = slices.DeleteFunc(result, func(algorithm string) bool {
+		return !slices.Contains(defaults, algorithm) && !slices.Contains(insecure, algorithm)
+	})
+	if len(result) == 0 {
+		return nil, fmt.Errorf(
+			"invalid %s %q: the builtin client supports none of the algorithms, it supports %s",
+			directive,
+			spec,
+			strings.Join(append(slices.Clone(defaults), insecure...), ","),
+		)
+	}
+
+	return result, nil
+}
+
+func splitAlgorithms(list string) []string {
+	result := []string{}
+	for _, algorithm := range strings.Split(list, ",") {
+		algorithm = strings.TrimSpace(algorithm)
+		if algorithm != "" {
+			result = append(result, algorithm)
+		}
+	}
+
+	return result
+}
+
+func appendAlgorithms(list []string, algorithms []string) []string {
+	for _, algorithm := range algorithms {
+		if !slices.Contains(list, algorithm) {
+			list = append(list, algorithm)
+		}
+	}
+
+	return list
+}
+
+// getAlgorithmFlags returns the ssh and scp flags for the algorithm options.
+func getAlgorithmFlags(provider *SSHProvider) []string {
+	result := []string{}
+	for _, option := range []struct {
+		directive string
+		value     string
+	}{
+		{"Ciphers", provider.Config.Ciphers},
+		{"KexAlgorithms", provider.Config.KexAlgorithms},
+		{"MACs", provider.Config.MACs},
+		{"HostKeyAlgorithms", provider.Config.HostKeyAlgorithms},
+	} {
+		if option.value != "" {
+			result = append(result, "-o"+option.directive+"="+option.value)
+		}
+	}
+
+	return result
+}
@@ -0,0 +1,65 @@
+package ssh
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestAlgorithmList(t *testing.T) {
+	defaults := []string{"aes128-ctr", "aes256-ctr", "chacha20-poly1305@openssh.com"}
+	insecure := []string{"aes128-cbc"}
+
+	tests := []struct {
+		spec string
+		want []string
+		err  bool
+	}{
+		{spec: "", want: nil},
+		{spec: "aes256-ctr,aes128-ctr", want: []string{"aes256-ctr", "aes128-ctr"}},
+		{spec: "aes256-ctr, aes128-ctr,", want: []string{"aes256-ctr", "aes128-ctr"}},
+		{spec: "aes128-ctr,unknown", want: []string{"aes128-ctr"}},
+		{spec: "aes128-cbc", want: []string{"aes128-cbc"}},
+		{
+			spec: "+aes128-cbc",
+			want: []string{"aes128-ctr", "aes256-ctr", "chacha20-poly1305@openssh.com", "aes128-cbc"},
+		},
+		{
+			spec: "+aes128-ctr",
+			want: []string{"aes128-ctr", "aes256-ctr", "chacha20-poly1305@openssh.com"},
+		},
+		{spec: "-aes256-ctr", want: []string{"aes128-ctr", "chacha20-poly1305@openssh.com"}},
+		{spec: "-aes*", want: []string{"chacha20-poly1305@openssh.com"}},
+		{spec: "-aes128-ctr,chacha20*", want: []string{"aes256-ctr"}},
+		{
+			spec: "^chacha20-poly1305@openssh.com",
+			want: []string{"chacha20-poly1305@openssh.com", "aes128-ctr", "aes256-ctr"},
+		},
+		{
+			spec: "^aes128-cbc",
+			want: []string{"aes128-cbc", "aes128-ctr", "aes256-ctr", "chacha20-poly1305@openssh.com"},
+		},
+		{spec: "unknown", err: true},
+		{spec: "-*", err: true},
+		{spec: "+", want: defaults},
+		{spec: ",", err: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.spec, func(t *testing.T) {
+			got, err := algorithmList("Ciphers", test.spec, defaults, insecure)
+			if test.err {
+				if err == nil {
+					t.Errorf("expected an error, got %q", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if !slices.Equal(got, test.want) {
+				t.Errorf("got %q, want %q", got, test.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,65 @@
+package ssh
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"runtime"
+	"strings"
+)
+
+var errNoAskpass = errors.New("no askpass program configured")
+
+// askpass asks for a secret using the SSH_ASKPASS program, the same way ssh
+// does when it has no terminal to prompt on.
+func askpass(prompt string) (string, error) {
+	program := os.Getenv("SSH_ASKPASS")
+	if program == "" || os.Getenv("SSH_ASKPASS_REQUIRE") == "never" {
+		return "", errNoAskpass
+	}
+
+	// #nosec G204 -- SSH_ASKPASS is configured by the user
+	cmd := exec.Command(program, prompt)
+	cmd.Stdin = os.Stdin
+	cmd.Stderr = os.Stderr
+
+	out, err := cmd.Output()
+	if err != nil {
+		return "", fmt.Errorf("run SSH_ASKPASS %s: %w", program, err)
+	}
+
+	return trimNewline(out), nil
+}
+
+// runSecretCommand runs command in the local shell and returns its output.
+// env is added to the environment of the command.
+func runSecretCommand(command string, env ...string) (string, error) {
+	cmd := shellCommand(command)
+	if len(env) > 0 {
+		cmd.Env = append(os.Environ(), env...)
+	}
+	cmd.Stdin = os.Stdin
+	cmd.Stderr = os.Stderr
+
+	out, err := cmd.Output()
+	if err != nil {
+		return "", err
+	}
+
+	return trimNewline(out), nil
+}
+
+func shellCommand(command string) *exec.Cmd {
+	if runtime.GOOS == "windows" {
+		// #nosec G204 -- command is configured by the user
+		return exec.Command("cmd", "/C", command)
+	}
+
+	// #nosec G204 -- command is configured by the user
+	return exec.Command("/bin/sh", "-c", command)
+}
+
+func trimNewline(out []byte) string {
+	return strings.TrimSuffix(strings.TrimSuffix(string(out), "\n"), "\r")
+}
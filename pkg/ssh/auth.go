@@ -0,0 +1,154 @@
+package ssh
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// newPublicKeyAuth returns the public key authentication of the builtin
+// client. Keys from the ssh agent are offered first, then the identity files.
+// The returned closer releases the connection to the agent. The method is nil
+// if there is no identity and interactive authentication is allowed.
+func newPublicKeyAuth(
+	provider *SSHProvider,
+	config *hostConfig,
+) (ssh.AuthMethod, io.Closer, error) {
+	files := loadIdentityFiles(provider, config)
+	certs, err := loadCertificates(provider, config)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	fromAgent, conn, err := agentSigners(provider, config)
+	if err != nil {
+		return nil, nil, err
+	}
+	var closer io.Closer = io.NopCloser(nil)
+	if conn != nil {
+		closer = conn
+	}
+
+	if config.IdentitiesOnly {
+		fromAgent = filterSigners(fromAgent, append(files.publicKeys, certificateKeys(certs)...))
+	}
+
+	signers := uniqueSigners(append(fromAgent, files.signers...))
+	certSigners, err := certificateSigners(provider, certs, signers)
+	if err != nil {
+		_ = closer.Close()
+		return nil, nil, err
+	}
+
+	signers = append(certSigners, signers...)
+	if len(signers) == 0 && provider.Config.AllowInteractiveAuth {
+		return nil, closer, nil
+	}
+	if len(signers) == 0 {
+		_ = closer.Close()
+		return nil, nil, fmt.Errorf(
+			"no usable identity for %s, add a key to your ssh agent or set IdentityFile in your ssh config",
+			config.Hostname,
+		)
+	}
+
+	return ssh.PublicKeys(signers...), closer, nil
+}
+
+type identities struct {
+	signers    []ssh.Signer
+	publicKeys []ssh.PublicKey
+}
+
+// loadIdentityFiles loads the identity files of the ssh config. Files that
+// don't exist are skipped, just like ssh does for the default identities.
+func loadIdentityFiles(provider *SSHProvider, config *hostConfig) *identities {
+	result := &identities{}
+	for _, file := range config.IdentityFiles {
+		path, err := expandPath(file)
+		if err != nil {
+			provider.Log.Debugf("skip identityfile %s: %v", file, err)
+			continue
+		}
+
+		if publicKey, err := readPublicKey(path + ".pub"); err == nil {
+			result.publicKeys = append(result.publicKeys, publicKey)
+		}
+
+		// #nosec G304 -- path comes from the user's ssh config
+		key, err := os.ReadFile(path)
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		} else if err != nil {
+			provider.Log.Warnf("read identityfile %s: %v", path, err)
+			continue
+		}
+
+		signer, err := parseIdentity(provider, path, key)
+		if err != nil {
+			provider.Log.Warn(err)
+			continue
+		}
+		result.signers = append(result.signers, signer)
+		result.publicKeys = append(result.publicKeys, signer.PublicKey())
+	}
+
+	return result
+}
+
+func parseIdentity(provider *SSHProvider, path string, key []byte) (ssh.Signer, error) {
+	signer, err := ssh.ParsePrivateKey(key)
+	missingErr := &ssh.PassphraseMissingError{}
+	if errors.As(err, &missingErr) {
+		return newEncryptedSigner(provider, path, key, missingErr.PublicKey)
+	} else if err != nil {
+		return nil, fmt.Errorf("parse identityfile %s: %w", path, err)
+	}
+
+	return signer, nil
+}
+
+func readPublicKey(path string) (ssh.PublicKey, error) {
+	// #nosec G304 -- path comes from the user's ssh config
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	publicKey, _, _, _, err := ssh.ParseAuthorizedKey(data)
+	return publicKey, err
+}
+
+// filterSigners returns the signers whose public key is in publicKeys.
+func filterSigners(signers []ssh.Signer, publicKeys []ssh.PublicKey) []ssh.Signer {
+	result := []ssh.Signer{}
+	for _, signer := range signers {
+		for _, publicKey := range publicKeys {
+			if bytes.Equal(signer.PublicKey().Marshal(), publicKey.Marshal()) {
+				result = append(result, signer)
+				break
+			}
+		}
+	}
+
+	return result
+}
+
+func uniqueSigners(signers []ssh.Signer) []ssh.Signer {
+	seen := map[string]bool{}
+	result := []ssh.Signer{}
+	for _, signer := range signers {
+		key := string(signer.PublicKey().Marshal())
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+		result = append(result, signer)
+	}
+
+	return result
+}
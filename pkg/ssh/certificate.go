@@ -0,0 +1,145 @@
+package ssh
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+type certificate struct {
+	path string
+	cert *ssh.Certificate
+}
+
+// loadCertificates loads the user certificates of CERTIFICATE_FILE, or of
+// CertificateFile and the <identityfile>-cert.pub files if it's not set.
+// Certificates are checked for expiry and principals before they are used.
+func loadCertificates(provider *SSHProvider, config *hostConfig) ([]*certificate, error) {
+	if provider.Config.CertificateFile != "" {
+		cert, err := loadCertificate(provider.Config.CertificateFile, config.User)
+		if err != nil {
+			return nil, err
+		}
+		return []*certificate{cert}, nil
+	}
+
+	files := slices.Clone(config.CertificateFiles)
+	for _, file := range config.IdentityFiles {
+		files = append(files, file+"-cert.pub")
+	}
+
+	result := []*certificate{}
+	for _, file := range files {
+		cert, err := loadCertificate(file, config.User)
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		} else if err != nil {
+			provider.Log.Warn(err)
+			continue
+		}
+		result = append(result, cert)
+	}
+
+	return result, nil
+}
+
+func loadCertificate(file string, user string) (*certificate, error) {
+	path, err := expandPath(file)
+	if err != nil {
+		return nil, err
+	}
+
+	publicKey, err := readPublicKey(path)
+	if err != nil {
+		return nil, fmt.Errorf("read certificate %s: %w", path, err)
+	}
+
+	cert, ok := publicKey.(*ssh.Certificate)
+	if !ok {
+		return nil, fmt.Errorf("read certificate %s: not an ssh certificate", path)
+	}
+
+	err = checkCertificate(cert, user, time.Now())
+	if err != nil {
+		return nil, fmt.Errorf("certificate %s %w", path, err)
+	}
+
+	return &certificate{path: path, cert: cert}, nil
+}
+
+func checkCertificate(cert *ssh.Certificate, user string, now time.Time) error {
+	if cert.CertType != ssh.UserCert {
+		return errors.New("is not a user certificate")
+	}
+
+	unix := uint64(now.Unix()) // #nosec G115 -- now is after 1970
+	if unix < cert.ValidAfter {
+		return fmt.Errorf("is not valid before %s", certTime(cert.ValidAfter))
+	}
+	if cert.ValidBefore != ssh.CertTimeInfinity && unix >= cert.ValidBefore {
+		return fmt.Errorf("expired at %s", certTime(cert.ValidBefore))
+	}
+
+	if len(cert.ValidPrincipals) > 0 && !slices.Contains(cert.ValidPrincipals, user) {
+		return fmt.Errorf(
+			"is not valid for user %s, valid principals are %s",
+			user,
+			strings.Join(cert.ValidPrincipals, ", "),
+		)
+	}
+
+	return nil
+}
+
+func certTime(t uint64) string {
+	return time.Unix(int64(t), 0).Format(time.RFC3339) // #nosec G115 -- certificate timestamp
+}
+
+// certificateSigners pairs each certificate with the signer of its private
+// key, which may come from the ssh agent or an identity file.
+func certificateSigners(
+	provider *SSHProvider,
+	certs []*certificate,
+	signers []ssh.Signer,
+) ([]ssh.Signer, error) {
+	result := []ssh.Signer{}
+	for _, cert := range certs {
+		index := slices.IndexFunc(signers, func(signer ssh.Signer) bool {
+			return bytes.Equal(signer.PublicKey().Marshal(), cert.cert.Key.Marshal())
+		})
+		if index < 0 {
+			err := fmt.Errorf(
+				"no private key for certificate %s, add it to your ssh agent or set IdentityFile in your ssh config",
+				cert.path,
+			)
+			if provider.Config.CertificateFile != "" {
+				return nil, err
+			}
+			provider.Log.Warn(err)
+			continue
+		}
+
+		signer, err := ssh.NewCertSigner(cert.cert, signers[index])
+		if err != nil {
+			return nil, fmt.Errorf("certificate %s: %w", cert.path, err)
+		}
+		result = append(result, signer)
+	}
+
+	return result, nil
+}
+
+func certificateKeys(certs []*certificate) []ssh.PublicKey {
+	result := []ssh.PublicKey{}
+	for _, cert := range certs {
+		result = append(result, cert.cert.Key)
+	}
+
+	return result
+}
@@ -0,0 +1,117 @@
+package ssh
+
+import (
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+func TestCheckCertificate(t *testing.T) {
+	now := time.Date(2025, 6, 1, 12, 0, 0, 0, time.UTC)
+	unix := uint64(now.Unix()) // #nosec G115 -- now is after 1970
+
+	tests := []struct {
+		name string
+		cert ssh.Certificate
+		user string
+		err  bool
+	}{
+		{
+			name: "valid",
+			cert: ssh.Certificate{
+				CertType:        ssh.UserCert,
+				ValidPrincipals: []string{"deploy", "admin"},
+				ValidAfter:      unix - 60,
+				ValidBefore:     unix + 60,
+			},
+			user: "admin",
+		},
+		{
+			name: "valid forever",
+			cert: ssh.Certificate{
+				CertType:        ssh.UserCert,
+				ValidPrincipals: []string{"deploy"},
+				ValidBefore:     ssh.CertTimeInfinity,
+			},
+			user: "deploy",
+		},
+		{
+			name: "valid from now on",
+			cert: ssh.Certificate{
+				CertType:    ssh.UserCert,
+				ValidAfter:  unix,
+				ValidBefore: ssh.CertTimeInfinity,
+			},
+			user: "deploy",
+		},
+		{
+			name: "no principals are valid for every user",
+			cert: ssh.Certificate{
+				CertType:    ssh.UserCert,
+				ValidBefore: ssh.CertTimeInfinity,
+			},
+			user: "anyone",
+		},
+		{
+			name: "host certificate",
+			cert: ssh.Certificate{
+				CertType:    ssh.HostCert,
+				ValidBefore: ssh.CertTimeInfinity,
+			},
+			user: "deploy",
+			err:  true,
+		},
+		{
+			name: "not yet valid",
+			cert: ssh.Certificate{
+				CertType:    ssh.UserCert,
+				ValidAfter:  unix + 1,
+				ValidBefore: ssh.CertTimeInfinity,
+			},
+			user: "deploy",
+			err:  true,
+		},
+		{
+			name: "expired",
+			cert: ssh.Certificate{
+				CertType:    ssh.UserCert,
+				ValidAfter:  unix - 60,
+				ValidBefore: unix - 1,
+			},
+			user: "deploy",
+			err:  true,
+		},
+		{
+			name: "expires now",
+			cert: ssh.Certificate{
+				CertType:    ssh.UserCert,
+				ValidBefore: unix,
+			},
+			user: "deploy",
+			err:  true,
+		},
+		{
+			name: "other principal",
+			cert: ssh.Certificate{
+				CertType:        ssh.UserCert,
+				ValidPrincipals: []string{"deploy"},
+				ValidBefore:     ssh.CertTimeInfinity,
+			},
+			user: "root",
+			err:  true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := checkCertificate(&test.cert, test.user, now)
+			if test.err && err == nil {
+				t.Error("expected an error")
+			}
+			if !test.err && err != nil {
+				t.Errorf("unexpected error: %v", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,285 @@
+package ssh
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+)
+
+// dialFunc opens a network connection, either directly or through a jump host.
+type dialFunc func(network string, addr string) (net.Conn, error)
+
+// newSession opens a session on the connection of the builtin client. The
+// connection is opened on first use and reused by later sessions, it is
+// reopened once if it was closed in the meantime.
+func newSession(ctx context.Context, provider *SSHProvider) (*ssh.Session, error) {
+	if provider.client != nil {
+		sess, err := provider.client.NewSession()
+		if err == nil {
+			requestAgentForwarding(provider, sess)
+			return sess, nil
+		}
+
+		provider.Log.Debugf("reconnect, create ssh session: %v", err)
+		_ = provider.Close()
+	}
+
+	client, err := connectBuiltin(ctx, provider)
+	if err != nil {
+		return nil, err
+	}
+
+	sess, err := client.NewSession()
+	if err != nil {
+		return nil, fmt.Errorf("create ssh session: %w", err)
+	}
+	requestAgentForwarding(provider, sess)
+
+	return sess, nil
+}
+
+// connectBuiltin connects the builtin client, retrying according to
+// CONNECT_RETRIES. The connection is shared by all commands of the process.
+func connectBuiltin(ctx context.Context, provider *SSHProvider) (*ssh.Client, error) {
+	var client *ssh.Client
+	err := withRetries(ctx, provider, func() (bool, error) {
+		var err error
+		client, err = newBuiltinClient(provider)
+		return isConnectError(err), err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("create ssh client: %w", err)
+	}
+	provider.lostMu.Lock()
+	provider.client = client
+	provider.lostMu.Unlock()
+
+	return client, nil
+}
+
+func requestAgentForwarding(provider *SSHProvider, sess *ssh.Session) {
+	if !provider.agentForwarding {
+		return
+	}
+
+	err := agent.RequestAgentForwarding(sess)
+	if err != nil {
+		provider.Log.Warnf("Agent forwarding request failed: %v", err)
+	}
+}
+
+// newBuiltinClient connects to the configured host using the builtin SSH
+// client, going through its jump hosts if there are any.
+func newBuiltinClient(provider *SSHProvider) (*ssh.Client, error) {
+	config, err := resolveConfig(provider)
+	if err != nil {
+		return nil, err
+	}
+
+	interval, countMax, err := keepAliveSettings(provider, config)
+	if err != nil {
+		return nil, err
+	}
+
+	dial, jump, err := dialJumpHosts(provider, config)
+	if err != nil {
+		return nil, err
+	}
+
+	client, err := dialHost(provider, config, dial)
+	if err != nil {
+		closeClient(jump)
+		return nil, err
+	}
+	chainClient(jump, client)
+	keepAlive(provider, client, interval, countMax)
+
+	provider.agentForwarding, err = forwardAgent(provider, client, config)
+	if err != nil {
+		_ = client.Close()
+		return nil, err
+	}
+
+	return client, nil
+}
+
+// dialJumpHosts connects to the jump hosts of config one after another. It
+// returns the dialer to reach the host through them and the last jump host,
+// which is nil if there are no jump hosts. Without jump hosts the dialer runs
+// the ProxyCommand of the host, if there is one.
+func dialJumpHosts(provider *SSHProvider, config *hostConfig) (dialFunc, *ssh.Client, error) {
+	jumps := jumpHosts(provider, config)
+	if len(jumps) == 0 {
+		return hostDialer(provider, config), nil, nil
+	}
+
+	var dial dialFunc
+	var jump *ssh.Client
+	for _, spec := range jumps {
+		user, host, port := parseJumpHost(spec)
+		hopConfig, err := resolveHostConfig(provider, host, user, port)
+		if err != nil {
+			closeClient(jump)
+			return nil, nil, fmt.Errorf("jump host %s: %w", spec, err)
+		}
+		if jump == nil {
+			dial = hostDialer(provider, hopConfig)
+		}
+
+		client, err := dialHost(provider, hopConfig, dial)
+		if err != nil {
+			closeClient(jump)
+			return nil, nil, fmt.Errorf("jump host %s: %w", spec, err)
+		}
+
+		chainClient(jump, client)
+		jump = client
+		dial = client.Dial
+	}
+
+	return dial, jump, nil
+}
+
+// dialHost opens a ssh connection to config using dial for the transport.
+func dialHost(provider *SSHProvider, config *hostConfig, dial dialFunc) (*ssh.Client, error) {
+	publicKeyAuth, agentConn, err := newPublicKeyAuth(provider, config)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = agentConn.Close() }()
+
+	auth := []ssh.AuthMethod{}
+	if publicKeyAuth != nil {
+		auth = append(auth, publicKeyAuth)
+	}
+	if provider.Config.AllowInteractiveAuth {
+		auth = append(auth, interactiveAuth(provider, config)...)
+	}
+
+	crypto, err := cryptoConfig(config)
+	if err != nil {
+		return nil, err
+	}
+
+	hostKeyCallback, hostKeyAlgorithms, err := newHostKeyCallback(provider, config, dial)
+	if err != nil {
+		return nil, err
+	}
+	if hostKeyAlgorithms == nil {
+		hostKeyAlgorithms, err = configHostKeyAlgorithms(config)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	clientConfig := &ssh.ClientConfig{
+		Config:            crypto,
+		User:              config.User,
+		Auth:              auth,
+		HostKeyCallback:   hostKeyCallback,
+		HostKeyAlgorithms: hostKeyAlgorithms,
+	}
+
+	addr := net.JoinHostPort(config.Hostname, config.Port)
+	return newClient(addr, clientConfig, dial, config.ConnectTimeout)
+}
+
+// newClient opens a ssh connection to addr. The handshake is aborted after
+// timeout, if it is set.
+func newClient(
+	addr string,
+	clientConfig *ssh.ClientConfig,
+	dial dialFunc,
+	timeout time.Duration,
+) (*ssh.Client, error) {
+	conn, err := dial("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+
+	// not every transport supports deadlines, so the connection is closed
+	// instead
+	var timedOut atomic.Bool
+	if timeout > 0 {
+		timer := time.AfterFunc(timeout, func() {
+			timedOut.Store(true)
+			_ = conn.Close()
+		})
+		defer timer.Stop()
+	}
+
+	c, chans, reqs, err := ssh.NewClientConn(conn, addr, clientConfig)
+	if err != nil {
+		_ = conn.Close()
+		if timedOut.Load() {
+			return nil, fmt.Errorf("%w after %s with %s", errHandshakeTimeout, timeout, addr)
+		}
+		return nil, err
+	}
+
+	return ssh.NewClient(c, chans, reqs), nil
+}
+
+// jumpHosts returns the jump hosts from JUMP_HOSTS, the ProxyJump directive
+// or a ssh PROXY_URL.
+func jumpHosts(provider *SSHProvider, config *hostConfig) []string {
+	jumps := config.ProxyJump
+	if provider.Config.JumpHosts != "" {
+		jumps = provider.Config.JumpHosts
+	}
+	if jumps == "" {
+		jumps = proxyJump(provider, config)
+	}
+	if jumps == "" || jumps == "none" {
+		return nil
+	}
+
+	result := []string{}
+	for _, spec := range strings.Split(jumps, ",") {
+		spec = strings.TrimSpace(spec)
+		if spec != "" {
+			result = append(result, spec)
+		}
+	}
+
+	return result
+}
+
+// parseJumpHost splits a [user@]host[:port] or ssh:// jump host.
+func parseJumpHost(spec string) (user string, host string, port string) {
+	spec = strings.TrimPrefix(spec, "ssh://")
+	if i := strings.LastIndex(spec, "@"); i >= 0 {
+		user, spec = spec[:i], spec[i+1:]
+	}
+
+	host = spec
+	if h, p, err := net.SplitHostPort(spec); err == nil {
+		host, port = h, p
+	}
+
+	return user, strings.Trim(host, "[]"), port
+}
+
+// chainClient closes the jump host once the client going through it is closed.
+func chainClient(jump *ssh.Client, client *ssh.Client) {
+	if jump == nil {
+		return
+	}
+
+	go func() {
+		_ = client.Wait()
+		_ = jump.Close()
+	}()
+}
+
+func closeClient(client *ssh.Client) {
+	if client != nil {
+		_ = client.Close()
+	}
+}
@@ -0,0 +1,160 @@
+package ssh
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/skevetter/devpod-provider-ssh/pkg/sshconfig"
+)
+
+// hostConfig holds the ssh_config directives the builtin client uses.
+type hostConfig struct {
+	Host                  string
+	Hostname              string
+	User                  string
+	Port                  string
+	IdentityFiles         []string
+	CertificateFiles      []string
+	IdentitiesOnly        bool
+	IdentityAgent         string
+	ForwardAgent          string
+	UserKnownHostsFiles   []string
+	GlobalKnownHostsFiles []string
+	ProxyJump             string
+	ProxyCommand          string
+	ServerAliveInterval   string
+	ServerAliveCountMax   string
+	ConnectTimeout        time.Duration
+	Ciphers               string
+	KexAlgorithms         string
+	MACs                  string
+	HostKeyAlgorithms     string
+
+	// HostKeyFingerprint pins the host key, it is only set for the target host
+	// and not for its jump hosts.
+	HostKeyFingerprint string
+}
+
+// resolveConfig resolves the ssh config of the configured host.
+func resolveConfig(provider *SSHProvider) (*hostConfig, error) {
+	config, err := resolveHostConfig(
+		provider,
+		provider.Config.Host,
+		provider.Config.User,
+		provider.Config.Port,
+	)
+	if err != nil {
+		return nil, err
+	}
+	config.HostKeyFingerprint = provider.Config.HostKeyFingerprint
+
+	// like the flags of the ssh binary the options don't apply to jump hosts
+	for _, option := range []struct {
+		value  string
+		target *string
+	}{
+		{provider.Config.Ciphers, &config.Ciphers},
+		{provider.Config.KexAlgorithms, &config.KexAlgorithms},
+		{provider.Config.MACs, &config.MACs},
+		{provider.Config.HostKeyAlgorithms, &config.HostKeyAlgorithms},
+	} {
+		if option.value != "" {
+			*option.target = option.value
+		}
+	}
+
+	return config, nil
+}
+
+// resolveHostConfig resolves the ssh config of host, user and port override
+// the ssh config if they are set. The config is only resolved once per
+// provider, Match exec runs commands that may have side effects.
+func resolveHostConfig(
+	provider *SSHProvider,
+	host string,
+	user string,
+	port string,
+) (*hostConfig, error) {
+	key := strings.Join([]string{host, user, port}, "\x00")
+	config, ok := provider.hostConfigs[key]
+	if !ok {
+		var err error
+		config, err = readHostConfig(provider, host, user, port)
+		if err != nil {
+			return nil, err
+		}
+		if provider.hostConfigs == nil {
+			provider.hostConfigs = map[string]*hostConfig{}
+		}
+		provider.hostConfigs[key] = config
+	}
+
+	// callers change their copy, like the target host does with the options
+	copied := *config
+	return &copied, nil
+}
+
+// readHostConfig reads the ssh config files for host.
+func readHostConfig(
+	provider *SSHProvider,
+	host string,
+	user string,
+	port string,
+) (*hostConfig, error) {
+	sshConfig, err := sshconfig.Resolve(host, user, port)
+	if err != nil {
+		return nil, fmt.Errorf("read ssh config for host %s: %w", host, err)
+	}
+	for _, warning := range sshConfig.Warnings() {
+		provider.Log.Debugf("read ssh config for host %s: %s", host, warning)
+	}
+
+	timeout, err := connectTimeout(provider, sshConfig.Get("connecttimeout"))
+	if err != nil {
+		return nil, fmt.Errorf("read ssh config for host %s: %w", host, err)
+	}
+
+	return &hostConfig{
+		Host:                  host,
+		Hostname:              sshConfig.Get("hostname"),
+		User:                  sshConfig.Get("user"),
+		Port:                  sshConfig.Get("port"),
+		IdentityFiles:         sshConfig.GetAll("identityfile"),
+		CertificateFiles:      sshConfig.GetAll("certificatefile"),
+		IdentitiesOnly:        sshConfig.Get("identitiesonly") == "yes",
+		IdentityAgent:         sshConfig.Get("identityagent"),
+		ForwardAgent:          sshConfig.Get("forwardagent"),
+		UserKnownHostsFiles:   sshConfig.GetAll("userknownhostsfile"),
+		GlobalKnownHostsFiles: sshConfig.GetAll("globalknownhostsfile"),
+		ProxyJump:             sshConfig.Get("proxyjump"),
+		ProxyCommand:          sshConfig.Get("proxycommand"),
+		ServerAliveInterval:   sshConfig.Get("serveraliveinterval"),
+		ServerAliveCountMax:   sshConfig.Get("serveralivecountmax"),
+		ConnectTimeout:        timeout,
+		Ciphers:               sshConfig.Get("ciphers"),
+		KexAlgorithms:         sshConfig.Get("kexalgorithms"),
+		MACs:                  sshConfig.Get("macs"),
+		HostKeyAlgorithms:     sshConfig.Get("hostkeyalgorithms"),
+	}, nil
+}
+
+// expandPath expands a leading ~ to the home directory and returns an absolute path.
+func expandPath(path string) (string, error) {
+	if path == "~" || strings.HasPrefix(path, "~/") || strings.HasPrefix(path, `~\`) {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return "", fmt.Errorf("home directory: %w", err)
+		}
+		path = filepath.Join(home, path[1:])
+	}
+
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return "", fmt.Errorf("absolute filepath: %w", err)
+	}
+
+	return abs, nil
+}
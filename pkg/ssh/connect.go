@@ -0,0 +1,148 @@
+package ssh
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os/exec"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/skevetter/devpod-provider-ssh/pkg/options"
+)
+
+// maxBackoff caps the delay between two connection attempts.
+const maxBackoff = time.Second * 30
+
+var errHandshakeTimeout = errors.New("ssh handshake timed out")
+
+// sshConnectErrors are the stderr lines of ssh that show it couldn't
+// establish the connection. They are all printed before a session is opened,
+// so no remote command ran.
+var sshConnectErrors = []string{
+	"ssh: connect to host ",
+	"ssh: Could not resolve hostname ",
+	"kex_exchange_identification: ",
+	"Connection closed by ",
+	"Connection reset by ",
+	"Connection timed out during banner exchange",
+}
+
+// connectTimeout returns CONNECT_TIMEOUT if it is set, otherwise the
+// ConnectTimeout of the ssh config.
+func connectTimeout(provider *SSHProvider, configTimeout string) (time.Duration, error) {
+	timeout := provider.Config.ConnectTimeout
+	if timeout == "" {
+		timeout = configTimeout
+	}
+	if timeout == "" || timeout == "none" {
+		return 0, nil
+	}
+
+	duration, err := options.ParseSeconds(timeout)
+	if err != nil {
+		return 0, fmt.Errorf("invalid ConnectTimeout: %w", err)
+	}
+
+	return roundUpSeconds(duration), nil
+}
+
+// getConnectTimeoutFlags returns the ssh and scp flags for CONNECT_TIMEOUT.
+func getConnectTimeoutFlags(provider *SSHProvider) []string {
+	if provider.Config.ConnectTimeout == "" {
+		return nil
+	}
+
+	timeout, _ := options.ParseSeconds(provider.Config.ConnectTimeout)
+	return []string{fmt.Sprintf("-oConnectTimeout=%d", int(roundUpSeconds(timeout).Seconds()))}
+}
+
+// roundUpSeconds rounds d up to whole seconds. The ssh binary only takes
+// seconds and would read a fraction rounded down to 0 as no timeout, the
+// builtin client is rounded the same way.
+func roundUpSeconds(d time.Duration) time.Duration {
+	return (d + time.Second - 1).Truncate(time.Second)
+}
+
+// withRetries calls connect until it succeeds, fails with an error it doesn't
+// report as retryable or CONNECT_RETRIES is exhausted. The delay between two
+// attempts starts at CONNECT_BACKOFF and doubles every time. Retrying stops
+// when ctx is canceled.
+func withRetries(ctx context.Context, provider *SSHProvider, connect func() (bool, error)) error {
+	backoff := provider.Config.ConnectBackoff
+	for attempt := 0; ; attempt++ {
+		retryable, err := connect()
+		if err == nil || !retryable || attempt >= provider.Config.ConnectRetries || ctx.Err() != nil {
+			return err
+		}
+
+		provider.Log.Warnf(
+			"Connection to %s failed, retrying in %s (%d/%d): %v",
+			provider.Config.Host,
+			backoff,
+			attempt+1,
+			provider.Config.ConnectRetries,
+			err,
+		)
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(backoff):
+		}
+		backoff = min(backoff*2, maxBackoff)
+	}
+}
+
+// isConnectError returns whether the builtin client failed to establish the
+// connection for a reason that may go away on its own, like a refused or
+// timed out dial or a connection dropped during the handshake. Failed
+// authentication or host key verification is not retried.
+func isConnectError(err error) bool {
+	var opErr *net.OpError
+	var dnsErr *net.DNSError
+	return errors.As(err, &opErr) ||
+		errors.As(err, &dnsErr) ||
+		errors.Is(err, io.EOF) ||
+		errors.Is(err, io.ErrUnexpectedEOF) ||
+		errors.Is(err, syscall.ECONNRESET) ||
+		errors.Is(err, errHandshakeTimeout)
+}
+
+// sshConnectError is a failed connection of the ssh binary, it reports the
+// message ssh printed instead of its exit status.
+type sshConnectError struct {
+	err     error
+	message string
+}
+
+func (e *sshConnectError) Error() string {
+	return e.message
+}
+
+func (e *sshConnectError) Unwrap() error {
+	return e.err
+}
+
+// checkSSHConnectError returns whether the ssh binary failed to establish the
+// connection and the error to report for it. ssh exits with 255 in that case,
+// but so may the remote command, so its stderr is checked as well.
+func checkSSHConnectError(err error, stderr string) (bool, error) {
+	exitErr := &exec.ExitError{}
+	if !errors.As(err, &exitErr) || exitErr.ExitCode() != 255 {
+		return false, err
+	}
+
+	for _, line := range strings.Split(stderr, "\n") {
+		line = strings.TrimSpace(line)
+		for _, prefix := range sshConnectErrors {
+			if strings.HasPrefix(line, prefix) {
+				return true, &sshConnectError{err: err, message: line}
+			}
+		}
+	}
+
+	return false, err
+}
@@ -0,0 +1,128 @@
+package ssh
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+)
+
+// controlMasterEnabled returns whether the ssh binary should share one
+// connection through a control master. Windows OpenSSH doesn't support it.
+func controlMasterEnabled(provider *SSHProvider) bool {
+	return runtime.GOOS != "windows" &&
+		provider.Config.ControlPersist != "no" &&
+		!provider.controlDisabled
+}
+
+// getControlFlags returns the flags that make ssh and scp use the connection
+// of the control master. ssh connects directly if the master isn't running.
+func getControlFlags(provider *SSHProvider) []string {
+	if !controlMasterEnabled(provider) {
+		return nil
+	}
+
+	return []string{"-oControlMaster=no", "-oControlPath=" + controlPath(provider)}
+}
+
+// startControlMaster starts a control master in the background unless one is
+// already running. It stays open for CONTROL_PERSIST after the last command.
+// If it can't be started connection reuse is disabled for this process.
+func startControlMaster(ctx context.Context, provider *SSHProvider) {
+	if !controlMasterEnabled(provider) || provider.controlStarted {
+		return
+	}
+	provider.controlStarted = true
+
+	socket := controlPath(provider)
+	err := os.MkdirAll(filepath.Dir(socket), 0o700)
+	if err != nil {
+		provider.Log.Debugf("disable connection reuse: %v", err)
+		provider.controlDisabled = true
+		return
+	}
+
+	// #nosec G204 -- the arguments come from the provider options
+	check := exec.Command("ssh", "-O", "check", "-oControlPath="+socket, provider.Config.Host)
+	if check.Run() == nil {
+		return
+	}
+
+	sshArgs, err := getSSHCommand(provider)
+	if err != nil {
+		provider.Log.Debugf("disable connection reuse: %v", err)
+		provider.controlDisabled = true
+		return
+	}
+
+	// ssh uses the first value of an option, so the master options go first
+	args := []string{
+		"-oControlMaster=auto",
+		"-oControlPersist=" + provider.Config.ControlPersist,
+		"-fN",
+	}
+	args = append(args, sshArgs...)
+
+	// stdin, stdout and stderr are left empty, a pipe would be held open by
+	// the master and block until it exits
+	err = sshCommand(ctx, provider, "ssh", args...).Run()
+	if err != nil {
+		provider.Log.Debugf("disable connection reuse, start ssh control master: %v", err)
+		provider.controlDisabled = true
+	}
+}
+
+// controlPath returns the control socket of the configured host. It is kept
+// short because unix sockets are limited to around 100 characters. Every
+// option that changes how the host is reached, trusted or authenticated to,
+// or how the connection is kept alive, is part of the name, so a master opened
+// with other settings is never reused. The key passphrase isn't, it doesn't
+// change which key is used.
+func controlPath(provider *SSHProvider) string {
+	proxyURL := ""
+	if provider.Config.ProxyURL != nil {
+		proxyURL = provider.Config.ProxyURL.String()
+	}
+
+	sum := sha256.Sum256([]byte(strings.Join([]string{
+		provider.Config.User,
+		provider.Config.Host,
+		provider.Config.Port,
+		provider.Config.JumpHosts,
+		provider.Config.ExtraFlags,
+		provider.Config.StrictHostKeyChecking,
+		provider.Config.HostKeyFingerprint,
+		provider.Config.KnownHostsFile,
+		proxyURL,
+		provider.Config.NoProxy,
+		provider.Config.CertificateFile,
+		provider.Config.KeyPassphraseCommand,
+		provider.Config.PasswordCommand,
+		strconv.FormatBool(provider.Config.AllowInteractiveAuth),
+		strconv.FormatBool(provider.Config.ForwardAgent),
+		provider.Config.ServerAliveInterval,
+		provider.Config.ServerAliveCountMax,
+		provider.Config.ConnectTimeout,
+		provider.Config.Ciphers,
+		provider.Config.KexAlgorithms,
+		provider.Config.MACs,
+		provider.Config.HostKeyAlgorithms,
+	}, "\x00")))
+
+	return filepath.Join(cacheDir(), "cm-"+hex.EncodeToString(sum[:8]))
+}
+
+// cacheDir returns the directory the provider keeps its state in.
+func cacheDir() string {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		dir = os.TempDir()
+	}
+
+	return filepath.Join(dir, "devpod-provider-ssh")
+}
@@ -0,0 +1,498 @@
+package ssh
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"path"
+	"slices"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/kballard/go-shellquote"
+	"golang.org/x/crypto/ssh"
+)
+
+// The results of a doctor check.
+const (
+	CheckPassed  = "pass"
+	CheckFailed  = "fail"
+	CheckSkipped = "skip"
+)
+
+// doctorTimeout limits every network check if no ConnectTimeout is set.
+const doctorTimeout = time.Second * 10
+
+// connectionFix is the fix of remote commands that fail after the login
+// succeeded.
+const connectionFix = "Check that the connection to the host is stable and run doctor again."
+
+// algorithmsFix is the fix of a key exchange that fails because the host
+// shares no algorithms with the provider.
+const algorithmsFix = "The host shares no algorithms with the provider, " +
+	"check CIPHERS, KEX_ALGORITHMS, MACS and HOST_KEY_ALGORITHMS."
+
+// errProbe fails an authentication method without sending credentials. The
+// client then goes on with the next method the server offers.
+var errProbe = errors.New("authentication probe")
+
+// Check is the result of one step of Doctor. Failed checks have a fix.
+type Check struct {
+	Name   string `json:"name"`
+	Result string `json:"result"`
+	Detail string `json:"detail,omitempty"`
+	Fix    string `json:"fix,omitempty"`
+}
+
+// doctorStep is one check of Doctor. If a required step fails the following
+// ones can't run and are skipped.
+type doctorStep struct {
+	name     string
+	required bool
+	run      func(ctx context.Context, d *doctor) Check
+}
+
+// doctor holds the state shared by the steps of Doctor.
+type doctor struct {
+	provider *SSHProvider
+	config   *hostConfig
+	addr     string
+
+	dial     dialFunc
+	jump     *ssh.Client
+	conn     net.Conn
+	timer    *time.Timer
+	timedOut atomic.Bool
+
+	offered []string
+	output  string
+}
+
+var doctorSteps = []doctorStep{
+	{name: "DNS resolution", required: true, run: checkDNS},
+	{name: "TCP connection", required: true, run: checkTCP},
+	{name: "SSH banner", required: true, run: checkBanner},
+	{name: "Key exchange", required: true, run: checkKeyExchange},
+	{name: "Authentication methods", required: true, run: checkAuthMethods},
+	{name: "Login", required: true, run: checkLogin},
+	{name: "Shell startup output", run: checkShellOutput},
+	{name: "Operating system", run: checkOperatingSystem},
+	{name: "Docker access", run: checkDocker},
+	{name: "AGENT_PATH writable", run: checkAgentPath},
+}
+
+// Doctor checks each layer of the connection to the host in turn, from name
+// resolution up to the requirements of the DevPod agent. The network layers
+// are probed with the builtin client, the remote commands use the configured
+// transport. It only returns an error if ctx is canceled.
+func Doctor(ctx context.Context, provider *SSHProvider) ([]Check, error) {
+	provider.Config.ConnectRetries = 0
+
+	d := &doctor{provider: provider}
+	defer d.close()
+
+	checks := []Check{}
+	failed := ""
+	for _, step := range doctorSteps {
+		var check Check
+		if failed != "" {
+			check = skipped("requires " + failed)
+		} else {
+			check = step.run(ctx, d)
+		}
+		if ctx.Err() != nil {
+			return nil, context.Cause(ctx)
+		}
+
+		check.Name = step.name
+		if check.Result == CheckFailed && step.required {
+			failed = step.name
+		}
+		checks = append(checks, check)
+	}
+
+	return checks, nil
+}
+
+func passed(detail string) Check {
+	return Check{Result: CheckPassed, Detail: detail}
+}
+
+func failed(detail string, fix string) Check {
+	return Check{Result: CheckFailed, Detail: detail, Fix: fix}
+}
+
+func skipped(detail string) Check {
+	return Check{Result: CheckSkipped, Detail: detail}
+}
+
+func (d *doctor) close() {
+	if d.timer != nil {
+		d.timer.Stop()
+	}
+	if d.conn != nil {
+		_ = d.conn.Close()
+	}
+	closeClient(d.jump)
+	d.timer, d.conn, d.jump = nil, nil, nil
+}
+
+// indirect returns what connects to the host if the provider doesn't.
+func (d *doctor) indirect() string {
+	switch {
+	case len(jumpHosts(d.provider, d.config)) > 0:
+		return "the jump hosts"
+	case d.config.ProxyCommand != "" && d.config.ProxyCommand != "none":
+		return "the ProxyCommand"
+	case d.provider.Config.ProxyURL != nil && useProxy(d.provider, d.addr):
+		return "the proxy"
+	}
+
+	return ""
+}
+
+// deadline closes the connection if the current step takes longer than the
+// connect timeout, not every transport supports deadlines.
+func (d *doctor) deadline() {
+	if d.timer == nil {
+		conn := d.conn
+		d.timer = time.AfterFunc(d.config.ConnectTimeout, func() {
+			d.timedOut.Store(true)
+			_ = conn.Close()
+		})
+		return
+	}
+
+	d.timer.Reset(d.config.ConnectTimeout)
+}
+
+func (d *doctor) networkError(err error) string {
+	if d.timedOut.Load() {
+		return fmt.Sprintf("no answer within %s", d.config.ConnectTimeout)
+	}
+
+	return err.Error()
+}
+
+func checkDNS(ctx context.Context, d *doctor) Check {
+	if useLocalTransport(d.provider) {
+		return skipped("commands run on the local host")
+	}
+
+	var err error
+	d.config, err = resolveConfig(d.provider)
+	if err != nil {
+		return failed(err.Error(), "Fix the ssh config of the host.")
+	}
+	if d.config.ConnectTimeout == 0 {
+		d.config.ConnectTimeout = doctorTimeout
+	}
+	d.addr = net.JoinHostPort(d.config.Hostname, d.config.Port)
+
+	if via := d.indirect(); via != "" {
+		return skipped("resolved by " + via)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, d.config.ConnectTimeout)
+	defer cancel()
+
+	addrs, err := net.DefaultResolver.LookupHost(ctx, d.config.Hostname)
+	if err != nil {
+		return failed(err.Error(), fmt.Sprintf(
+			"Check that %s is the right host name, or add it to your ssh config or hosts file.",
+			d.config.Hostname,
+		))
+	}
+
+	return passed(d.config.Hostname + " is " + strings.Join(addrs, ", "))
+}
+
+func checkTCP(_ context.Context, d *doctor) Check {
+	if d.config == nil {
+		return skipped("commands run on the local host")
+	}
+
+	var err error
+	d.dial, d.jump, err = dialJumpHosts(d.provider, d.config)
+	if err != nil {
+		return failed(err.Error(), "Make sure every jump host of JUMP_HOSTS or ProxyJump can be reached with ssh.")
+	}
+
+	d.conn, err = d.dial("tcp", d.addr)
+	if err != nil {
+		return failed(err.Error(), fmt.Sprintf(
+			"Make sure the host is running, sshd listens on port %s and no firewall blocks it.",
+			d.config.Port,
+		))
+	}
+
+	return passed("connected to " + d.addr)
+}
+
+// checkBanner reads the identification string of the server. Servers may
+// send other lines before it.
+func checkBanner(_ context.Context, d *doctor) Check {
+	if d.conn == nil {
+		return skipped("commands run on the local host")
+	}
+
+	fix := fmt.Sprintf("Port %s doesn't answer like an ssh server, check PORT.", d.config.Port)
+	d.deadline()
+	reader := bufio.NewReader(d.conn)
+	read := new(bytes.Buffer)
+	for range 20 {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			return failed(d.networkError(err), fix)
+		}
+		read.WriteString(line)
+
+		line = strings.TrimRight(line, "\r\n")
+		if !strings.HasPrefix(line, "SSH-") {
+			continue
+		}
+		if !strings.HasPrefix(line, "SSH-2.0-") && !strings.HasPrefix(line, "SSH-1.99-") {
+			return failed(line, "The server only supports SSH protocol 1, update sshd on the host.")
+		}
+
+		// the handshake reads the identification string again
+		d.conn = &bufferedConn{Conn: d.conn, reader: bufio.NewReader(io.MultiReader(read, reader))}
+		return passed(line)
+	}
+
+	return failed("no SSH identification string", fix)
+}
+
+// checkKeyExchange runs the handshake with the host key verification of the
+// builtin client. Its authentication only records the methods the server
+// offers, without trying them.
+func checkKeyExchange(_ context.Context, d *doctor) Check {
+	if d.conn == nil {
+		return skipped("commands run on the local host")
+	}
+
+	crypto, err := cryptoConfig(d.config)
+	if err != nil {
+		return failed(err.Error(), algorithmsFix)
+	}
+
+	callback, algorithms, err := newHostKeyCallback(d.provider, d.config, d.dial)
+	if err != nil {
+		return failed(err.Error(), d.hostKeyFix())
+	}
+	if algorithms == nil {
+		algorithms, err = configHostKeyAlgorithms(d.config)
+		if err != nil {
+			return failed(err.Error(), algorithmsFix)
+		}
+	}
+
+	hostKey := ""
+	var hostKeyErr error
+	clientConfig := &ssh.ClientConfig{
+		Config: crypto,
+		User:   d.config.User,
+		Auth:   d.probeAuth(),
+		HostKeyCallback: func(hostname string, remote net.Addr, key ssh.PublicKey) error {
+			hostKeyErr = callback(hostname, remote, key)
+			if hostKeyErr == nil {
+				hostKey = key.Type() + " " + ssh.FingerprintSHA256(key)
+			}
+			return hostKeyErr
+		},
+		HostKeyAlgorithms: algorithms,
+	}
+
+	d.deadline()
+	c, chans, reqs, err := ssh.NewClientConn(d.conn, d.addr, clientConfig)
+	if err == nil {
+		// the server didn't ask for authentication
+		d.offered = append(d.offered, "none")
+		_ = ssh.NewClient(c, chans, reqs).Close()
+	}
+
+	switch {
+	case hostKeyErr != nil:
+		return failed(hostKeyErr.Error(), d.hostKeyFix())
+	case hostKey == "":
+		return failed(d.networkError(err), algorithmsFix)
+	}
+
+	return passed(hostKey)
+}
+
+func (d *doctor) hostKeyFix() string {
+	return fmt.Sprintf(
+		"Verify the host key of %s. If it changed on purpose remove the old one "+
+			"with ssh-keygen -R or set HOST_KEY_FINGERPRINT.",
+		d.config.Hostname,
+	)
+}
+
+// probeAuth returns authentication methods that record that the server offers
+// them. None of them sends credentials or ends the authentication, so the
+// client tries every method the server offers and the record is complete.
+func (d *doctor) probeAuth() []ssh.AuthMethod {
+	offer := func(method string) {
+		if !slices.Contains(d.offered, method) {
+			d.offered = append(d.offered, method)
+		}
+	}
+
+	return []ssh.AuthMethod{
+		ssh.PublicKeysCallback(func() ([]ssh.Signer, error) {
+			offer("publickey")
+			return nil, nil
+		}),
+		ssh.PasswordCallback(func() (string, error) {
+			offer("password")
+			return "", errProbe
+		}),
+		ssh.KeyboardInteractive(func(string, string, []string, []bool) ([]string, error) {
+			offer("keyboard-interactive")
+			return nil, errProbe
+		}),
+	}
+}
+
+func checkAuthMethods(_ context.Context, d *doctor) Check {
+	if d.config == nil {
+		return skipped("commands run on the local host")
+	}
+
+	detail := "offered " + strings.Join(d.offered, ", ")
+	if len(d.offered) == 0 {
+		detail = "no methods offered"
+	}
+
+	interactive := slices.Contains(d.offered, "password") ||
+		slices.Contains(d.offered, "keyboard-interactive")
+	switch {
+	case slices.Contains(d.offered, "publickey"), slices.Contains(d.offered, "none"):
+		return passed(detail)
+	case interactive && d.provider.Config.AllowInteractiveAuth:
+		return passed(detail)
+	case interactive:
+		return failed(detail, "The host only offers password authentication, add your public key "+
+			"to ~/.ssh/authorized_keys on the host or set ALLOW_INTERACTIVE_AUTH.")
+	}
+
+	return failed(detail, "Enable PubkeyAuthentication in the sshd_config of the host.")
+}
+
+// checkLogin runs an empty command, anything it prints comes from the shell
+// startup files.
+func checkLogin(ctx context.Context, d *doctor) Check {
+	d.close()
+
+	out := new(bytes.Buffer)
+	err := execSSHCommand(ctx, d.provider, "true", out)
+	if err != nil {
+		if useLocalTransport(d.provider) {
+			return failed(err.Error(), "Make sure /bin/sh can run commands on the local host.")
+		}
+		fix := "Add your public key to ~/.ssh/authorized_keys on the host or set its IdentityFile in your ssh config."
+		args, argsErr := getSSHCommand(d.provider)
+		if argsErr == nil {
+			fix += " Try the login with: ssh " + strings.Join(args, " ")
+		}
+		return failed(err.Error(), fix)
+	}
+	d.output = out.String()
+
+	if useLocalTransport(d.provider) {
+		return passed("commands run on the local host")
+	}
+	return passed("")
+}
+
+func checkShellOutput(_ context.Context, d *doctor) Check {
+	if d.output != "" {
+		return failed(
+			fmt.Sprintf("%q", d.output),
+			"Remove the output of the shell startup files on the host, like ~/.bashrc, for non-interactive shells.",
+		)
+	}
+
+	return passed("")
+}
+
+func checkOperatingSystem(ctx context.Context, d *doctor) Check {
+	system, err := d.run(ctx, "uname")
+	if err != nil {
+		return failed(err.Error(), "Make sure uname is in the PATH of the user on the host.")
+	}
+	if system != "Linux" {
+		return failed(system, "The provider only supports Linux hosts.")
+	}
+
+	return passed(system)
+}
+
+func checkDocker(ctx context.Context, d *doctor) Check {
+	docker := d.provider.Config.DockerPath + " ps >/dev/null 2>&1"
+	access, err := d.run(ctx, fmt.Sprintf(
+		"if %s; then echo yes; elif sudo -n %s; then echo sudo; else echo no; fi",
+		docker,
+		docker,
+	))
+	if err != nil {
+		return failed(err.Error(), connectionFix)
+	}
+
+	switch access {
+	case "yes":
+		return passed(d.provider.Config.DockerPath + " ps succeeded")
+	case "sudo":
+		return passed(d.provider.Config.DockerPath + " ps succeeded with sudo")
+	}
+
+	return failed(
+		d.provider.Config.DockerPath+" ps failed",
+		"Install docker on the host and add the user to the docker group, or allow passwordless "+
+			"sudo. Set DOCKER_PATH if docker isn't in the PATH.",
+	)
+}
+
+func checkAgentPath(ctx context.Context, d *doctor) Check {
+	dir := shellquote.Join(path.Dir(d.provider.Config.AgentPath))
+	access, err := d.run(ctx, fmt.Sprintf(
+		"if [ \"$(id -ru)\" = 0 ] || { mkdir -p %s && [ -w %s ]; } 2>/dev/null; then echo yes; "+
+			"elif sudo -nl >/dev/null 2>&1; then echo sudo; else echo no; fi",
+		dir,
+		dir,
+	))
+	if err != nil {
+		return failed(err.Error(), connectionFix)
+	}
+
+	switch access {
+	case "yes":
+		return passed(dir + " is writable")
+	case "sudo":
+		return passed(dir + " is writable with sudo")
+	}
+
+	return failed(
+		dir+" is not writable",
+		"Make the directory writable for the user, allow passwordless sudo or set AGENT_PATH to a writable location.",
+	)
+}
+
+// run runs command on the host and returns the last line of its output, the
+// lines before come from the shell startup files.
+func (d *doctor) run(ctx context.Context, command string) (string, error) {
+	out := new(bytes.Buffer)
+	err := execSSHCommand(ctx, d.provider, command, out)
+	if err != nil {
+		return "", err
+	}
+
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	return strings.TrimSpace(lines[len(lines)-1]), nil
+}
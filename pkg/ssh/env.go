@@ -0,0 +1,72 @@
+package ssh
+
+import (
+	"os"
+	"path"
+	"slices"
+	"strings"
+
+	"github.com/kballard/go-shellquote"
+	"golang.org/x/crypto/ssh"
+)
+
+// forwardedEnv returns the local environment variables matching FORWARD_ENV
+// as name=value, sorted by name.
+func forwardedEnv(provider *SSHProvider) []string {
+	if len(provider.Config.ForwardEnv) == 0 {
+		return nil
+	}
+
+	result := []string{}
+	for _, variable := range os.Environ() {
+		name, _, _ := strings.Cut(variable, "=")
+		if name == "" {
+			continue
+		}
+
+		for _, pattern := range provider.Config.ForwardEnv {
+			if matched, _ := path.Match(pattern, name); matched {
+				result = append(result, variable)
+				break
+			}
+		}
+	}
+	slices.Sort(result)
+
+	return result
+}
+
+// sendEnv sends the forwarded environment variables to sess with env
+// requests. Servers only accept the ones allowed by AcceptEnv, so the
+// returned command sets the rejected ones with env before it runs command.
+// The values are never logged.
+func sendEnv(provider *SSHProvider, sess *ssh.Session, command string) string {
+	rejected := []string{}
+	names := []string{}
+	for _, variable := range forwardedEnv(provider) {
+		name, value, _ := strings.Cut(variable, "=")
+		err := sess.Setenv(name, value)
+		if err != nil {
+			rejected = append(rejected, variable)
+			names = append(names, name)
+		}
+	}
+	if len(rejected) == 0 {
+		return command
+	}
+
+	provider.Log.Debugf("server rejected %s, set them with env", strings.Join(names, ", "))
+	args := append([]string{"env"}, rejected...)
+	return shellquote.Join(append(args, "/bin/sh", "-c", command)...)
+}
+
+// getSendEnvFlags returns the ssh flags that forward the environment
+// variables of FORWARD_ENV. ssh matches the patterns itself.
+func getSendEnvFlags(provider *SSHProvider) []string {
+	result := []string{}
+	for _, pattern := range provider.Config.ForwardEnv {
+		result = append(result, "-oSendEnv="+pattern)
+	}
+
+	return result
+}
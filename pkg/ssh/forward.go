@@ -0,0 +1,511 @@
+package ssh
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// The kinds of port forwarding, named after the flags of ssh.
+const (
+	ForwardLocal   = "L"
+	ForwardRemote  = "R"
+	ForwardDynamic = "D"
+)
+
+// forwardEstablished is printed by the LocalCommand of the ssh binary once
+// it is connected.
+const forwardEstablished = "devpod-provider-ssh: forwarding established"
+
+// portForward is a -L, -R or -D spec. listen is the address to listen on,
+// on the host for remote forwards. target is the address connections are
+// forwarded to, it is empty for dynamic forwards.
+type portForward struct {
+	kind   string
+	spec   string
+	listen string
+	target string
+}
+
+// forwardNetwork dials and listens on the host side of the forwards.
+type forwardNetwork interface {
+	Dial(network string, addr string) (net.Conn, error)
+	Listen(network string, addr string) (net.Listener, error)
+}
+
+// localNetwork is the host side of the forwards if the host is the local
+// host.
+type localNetwork struct{}
+
+func (localNetwork) Dial(network string, addr string) (net.Conn, error) {
+	return net.Dial(network, addr)
+}
+
+func (localNetwork) Listen(network string, addr string) (net.Listener, error) {
+	return net.Listen(network, addr)
+}
+
+// clientNetwork is the host side of the forwards over the builtin client.
+type clientNetwork struct {
+	*ssh.Client
+}
+
+// Listen listens on all interfaces of the host if the address has no host.
+// The client would send the missing IP as "<nil>" in the forward request.
+func (c clientNetwork) Listen(network string, addr string) (net.Listener, error) {
+	host, port, err := net.SplitHostPort(addr)
+	if err == nil && host == "" {
+		addr = net.JoinHostPort("0.0.0.0", port)
+	}
+
+	return c.Client.Listen(network, addr)
+}
+
+// ForwardSpecs are the specs of the -L, -R and -D flags of Forward.
+type ForwardSpecs struct {
+	Local   []string
+	Remote  []string
+	Dynamic []string
+}
+
+// Forward forwards ports like the -L, -R and -D flags of ssh until ctx is
+// canceled. If the connection drops it reconnects and requests the remote
+// forwards again, local listeners stay open in the meantime. Set
+// SERVER_ALIVE_INTERVAL to notice connections that drop silently.
+func Forward(ctx context.Context, provider *SSHProvider, specs ForwardSpecs) error {
+	forwards := []portForward{}
+	kinds := []string{ForwardLocal, ForwardRemote, ForwardDynamic}
+	for i, kindSpecs := range [][]string{specs.Local, specs.Remote, specs.Dynamic} {
+		for _, spec := range kindSpecs {
+			forward, err := parseForward(kinds[i], spec)
+			if err != nil {
+				return err
+			}
+			forwards = append(forwards, forward)
+		}
+	}
+	if len(forwards) == 0 {
+		return errors.New("no ports to forward, use -L, -R or -D")
+	}
+
+	switch {
+	case useLocalTransport(provider):
+		return forwardLocalHost(ctx, provider, forwards)
+	case provider.Config.UseBuiltinSSH:
+		return forwardBuiltin(ctx, provider, forwards)
+	}
+
+	return forwardExternal(ctx, provider, forwards)
+}
+
+// parseForward parses a spec the way ssh does, [bind_address:]port:host:hostport
+// for local and remote forwards and [bind_address:]port for dynamic ones.
+// IPv6 addresses are put in brackets. Without bind address only the loopback
+// interface is used, * or an empty address listens on all interfaces.
+func parseForward(kind string, spec string) (portForward, error) {
+	fields := splitForwardSpec(spec)
+	invalid := fmt.Errorf(
+		"invalid -%s %q, expected [bind_address:]port:host:hostport",
+		kind,
+		spec,
+	)
+	if kind == ForwardDynamic {
+		invalid = fmt.Errorf("invalid -%s %q, expected [bind_address:]port", kind, spec)
+	}
+
+	forward := portForward{kind: kind, spec: spec}
+	targetFields := 2
+	if kind == ForwardDynamic {
+		targetFields = 0
+	}
+	switch len(fields) {
+	case targetFields + 1:
+		fields = append([]string{"localhost"}, fields...)
+	case targetFields + 2:
+		if fields[0] == "*" {
+			fields[0] = ""
+		}
+	default:
+		return portForward{}, invalid
+	}
+
+	if !validPort(fields[1], true) {
+		return portForward{}, invalid
+	}
+	forward.listen = net.JoinHostPort(fields[0], fields[1])
+
+	if kind != ForwardDynamic {
+		if fields[2] == "" || !validPort(fields[3], false) {
+			return portForward{}, invalid
+		}
+		forward.target = net.JoinHostPort(fields[2], fields[3])
+	}
+
+	return forward, nil
+}
+
+// splitForwardSpec splits spec at colons outside of brackets and removes the
+// brackets.
+func splitForwardSpec(spec string) []string {
+	fields := []string{}
+	field := strings.Builder{}
+	bracket := false
+	for _, r := range spec {
+		switch {
+		case r == '[' && !bracket:
+			bracket = true
+		case r == ']' && bracket:
+			bracket = false
+		case r == ':' && !bracket:
+			fields = append(fields, field.String())
+			field.Reset()
+		default:
+			field.WriteRune(r)
+		}
+	}
+
+	return append(fields, field.String())
+}
+
+func validPort(port string, allowZero bool) bool {
+	number, err := strconv.Atoi(port)
+	if err != nil || number > 65535 {
+		return false
+	}
+
+	return number > 0 || (allowZero && number == 0)
+}
+
+// forwardListener is the listener of a forward.
+type forwardListener struct {
+	net.Listener
+	forward portForward
+}
+
+// forwarder connects the forwards to the current connection to the host.
+type forwarder struct {
+	provider *SSHProvider
+
+	mu     sync.Mutex
+	remote forwardNetwork
+}
+
+func (f *forwarder) setRemote(remote forwardNetwork) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.remote = remote
+}
+
+// dial connects to addr on the host, it fails while there is no connection.
+func (f *forwarder) dial(addr string) (net.Conn, error) {
+	f.mu.Lock()
+	remote := f.remote
+	f.mu.Unlock()
+
+	if remote == nil {
+		return nil, fmt.Errorf("connect to %s: not connected to %s", addr, f.provider.Config.Host)
+	}
+
+	return remote.Dial("tcp", addr)
+}
+
+// listenLocal opens the local listeners of the local and dynamic forwards.
+func listenLocal(forwards []portForward) ([]forwardListener, error) {
+	listeners := []forwardListener{}
+	for _, forward := range forwards {
+		if forward.kind == ForwardRemote {
+			continue
+		}
+
+		listener, err := net.Listen("tcp", forward.listen)
+		if err != nil {
+			closeListeners(listeners)
+			return nil, fmt.Errorf("-%s %s: %w", forward.kind, forward.spec, err)
+		}
+		listeners = append(listeners, forwardListener{listener, forward})
+	}
+
+	return listeners, nil
+}
+
+// listenRemote requests the listeners of the remote forwards on the host.
+func listenRemote(remote forwardNetwork, forwards []portForward) ([]forwardListener, error) {
+	listeners := []forwardListener{}
+	for _, forward := range forwards {
+		if forward.kind != ForwardRemote {
+			continue
+		}
+
+		listener, err := remote.Listen("tcp", forward.listen)
+		if err != nil {
+			closeListeners(listeners)
+			return nil, fmt.Errorf("-%s %s: %w", forward.kind, forward.spec, err)
+		}
+		listeners = append(listeners, forwardListener{listener, forward})
+	}
+
+	return listeners, nil
+}
+
+func closeListeners(listeners []forwardListener) {
+	for _, listener := range listeners {
+		_ = listener.Close()
+	}
+}
+
+// serve accepts the connections of the listeners until they are closed. The
+// ones of local and dynamic forwards are forwarded to the host, the ones of
+// remote forwards come from the host and are forwarded to the local target.
+func (f *forwarder) serve(listeners []forwardListener) {
+	for _, listener := range listeners {
+		go func() {
+			for {
+				conn, err := listener.Accept()
+				if err != nil {
+					return
+				}
+
+				go f.handle(conn, listener.forward)
+			}
+		}()
+	}
+}
+
+func (f *forwarder) handle(conn net.Conn, forward portForward) {
+	var target net.Conn
+	var err error
+	switch forward.kind {
+	case ForwardLocal:
+		target, err = f.dial(forward.target)
+	case ForwardRemote:
+		target, err = net.Dial("tcp", forward.target)
+	case ForwardDynamic:
+		var addr string
+		addr, err = socksHandshake(conn)
+		if err == nil {
+			target, err = f.dial(addr)
+			status := byte(socksSucceeded)
+			if err != nil {
+				status = socksConnRefused
+			}
+			socksReply(conn, status)
+		}
+	}
+	if err != nil {
+		f.provider.Log.Warnf("-%s %s: %v", forward.kind, forward.spec, err)
+		_ = conn.Close()
+		return
+	}
+
+	pipe(conn, target)
+}
+
+// pipe copies between a and b in both directions until both are done, then
+// closes them. The end of one direction is passed on as a half close.
+func pipe(a net.Conn, b net.Conn) {
+	var wg sync.WaitGroup
+	wg.Add(2)
+	copyHalf := func(dst net.Conn, src net.Conn) {
+		defer wg.Done()
+		_, _ = io.Copy(dst, src)
+		if closer, ok := dst.(interface{ CloseWrite() error }); ok {
+			_ = closer.CloseWrite()
+		} else {
+			_ = dst.Close()
+		}
+	}
+	go copyHalf(a, b)
+	go copyHalf(b, a)
+	wg.Wait()
+
+	_ = a.Close()
+	_ = b.Close()
+}
+
+// forwardLocalHost forwards between ports of the local host, no connection
+// is needed.
+func forwardLocalHost(ctx context.Context, provider *SSHProvider, forwards []portForward) error {
+	f := &forwarder{provider: provider, remote: localNetwork{}}
+	listeners, err := listenLocal(forwards)
+	if err != nil {
+		return err
+	}
+	defer func() { closeListeners(listeners) }()
+
+	remoteListeners, err := listenRemote(localNetwork{}, forwards)
+	if err != nil {
+		return err
+	}
+	listeners = append(listeners, remoteListeners...)
+
+	f.serve(listeners)
+	provider.Log.Infof("Forwarding ports on the local host, press Ctrl+C to stop")
+
+	<-ctx.Done()
+	return context.Cause(ctx)
+}
+
+// forwardBuiltin forwards over the builtin client. The first connection has
+// to succeed, after that it reconnects until ctx is canceled.
+func forwardBuiltin(ctx context.Context, provider *SSHProvider, forwards []portForward) error {
+	f := &forwarder{provider: provider}
+	listeners, err := listenLocal(forwards)
+	if err != nil {
+		return err
+	}
+	defer func() { closeListeners(listeners) }()
+	f.serve(listeners)
+
+	connected := false
+	backoff := max(provider.Config.ConnectBackoff, time.Second)
+	for {
+		client, err := f.connect(ctx, forwards)
+		switch {
+		case ctx.Err() != nil:
+			return context.Cause(ctx)
+		case err != nil && !connected:
+			return err
+		case err != nil:
+			provider.Log.Warnf("Reconnect to %s failed, retrying in %s: %v", provider.Config.Host, backoff, err)
+			if !sleep(ctx, backoff) {
+				return context.Cause(ctx)
+			}
+			backoff = min(backoff*2, maxBackoff)
+			continue
+		}
+
+		if connected {
+			provider.Log.Infof("Reconnected to %s", provider.Config.Host)
+		} else {
+			provider.Log.Infof("Forwarding ports of %s, press Ctrl+C to stop", provider.Config.Host)
+		}
+		connected = true
+		backoff = max(provider.Config.ConnectBackoff, time.Second)
+
+		err = f.use(ctx, client)
+		if ctx.Err() != nil {
+			return context.Cause(ctx)
+		}
+		provider.Log.Warnf("Connection to %s closed, reconnecting: %v", provider.Config.Host, err)
+	}
+}
+
+// connect connects the builtin client and requests the remote forwards.
+func (f *forwarder) connect(ctx context.Context, forwards []portForward) (clientNetwork, error) {
+	client, err := connectBuiltin(ctx, f.provider)
+	if err != nil {
+		return clientNetwork{}, err
+	}
+
+	remote := clientNetwork{client}
+	listeners, err := listenRemote(remote, forwards)
+	if err != nil {
+		_ = f.provider.Close()
+		return clientNetwork{}, err
+	}
+	f.serve(listeners)
+
+	return remote, nil
+}
+
+// use forwards over remote until its connection is closed or ctx is
+// canceled.
+func (f *forwarder) use(ctx context.Context, remote clientNetwork) error {
+	f.setRemote(remote)
+	err := waitClient(ctx, remote.Client)
+	f.setRemote(nil)
+	if lost := f.provider.connectionLost(); lost != nil {
+		err = lost
+	}
+	_ = f.provider.Close()
+
+	return err
+}
+
+// waitClient waits until client is closed or ctx is canceled.
+func waitClient(ctx context.Context, client *ssh.Client) error {
+	closed := make(chan error, 1)
+	go func() {
+		closed <- client.Wait()
+	}()
+
+	select {
+	case <-ctx.Done():
+		return context.Cause(ctx)
+	case err := <-closed:
+		return err
+	}
+}
+
+// forwardExternal forwards with the ssh binary and restarts it when it exits.
+// It has a connection of its own, so it doesn't use the control master. ssh
+// prints forwardEstablished once it is connected and the local forwards are
+// set up, if the first run fails before that or its remote forwards fail the
+// error is returned instead of reconnecting.
+func forwardExternal(ctx context.Context, provider *SSHProvider, forwards []portForward) error {
+	provider.controlDisabled = true
+	args, err := getSSHCommand(provider)
+	if err != nil {
+		return err
+	}
+
+	flags := []string{
+		"-N",
+		"-oExitOnForwardFailure=yes",
+		"-oPermitLocalCommand=yes",
+		"-oLocalCommand=echo " + forwardEstablished,
+	}
+	for _, forward := range forwards {
+		flags = append(flags, "-"+forward.kind, forward.spec)
+	}
+	args = append(flags, args...)
+
+	provider.Log.Infof("Forwarding ports of %s, press Ctrl+C to stop", provider.Config.Host)
+	connected := false
+	backoff := max(provider.Config.ConnectBackoff, time.Second)
+	for {
+		stdout := new(bytes.Buffer)
+		stderr := new(bytes.Buffer)
+		cmd := sshCommand(ctx, provider, "ssh", args...)
+		cmd.Stdout = stdout
+		cmd.Stderr = io.MultiWriter(os.Stderr, stderr)
+		err := canceled(ctx, cmd.Run())
+		established := strings.Contains(stdout.String(), forwardEstablished)
+		switch {
+		case ctx.Err() != nil:
+			return context.Cause(ctx)
+		case !connected && (!established || strings.Contains(stderr.String(), "forwarding failed")):
+			return err
+		case established:
+			backoff = max(provider.Config.ConnectBackoff, time.Second)
+		}
+		connected = true
+
+		provider.Log.Warnf("Connection to %s closed, reconnecting in %s: %v", provider.Config.Host, backoff, err)
+		if !sleep(ctx, backoff) {
+			return context.Cause(ctx)
+		}
+		backoff = min(backoff*2, maxBackoff)
+	}
+}
+
+// sleep waits for d and returns false if ctx was canceled before.
+func sleep(ctx context.Context, d time.Duration) bool {
+	select {
+	case <-ctx.Done():
+		return false
+	case <-time.After(d):
+		return true
+	}
+}
@@ -0,0 +1,60 @@
+package ssh
+
+import "testing"
+
+func TestParseForward(t *testing.T) {
+	tests := []struct {
+		kind   string
+		spec   string
+		listen string
+		target string
+		err    bool
+	}{
+		{kind: ForwardLocal, spec: "8080:web:80", listen: "localhost:8080", target: "web:80"},
+		{kind: ForwardLocal, spec: "0.0.0.0:8080:web:80", listen: "0.0.0.0:8080", target: "web:80"},
+		{kind: ForwardLocal, spec: "*:8080:web:80", listen: ":8080", target: "web:80"},
+		{kind: ForwardLocal, spec: ":8080:web:80", listen: ":8080", target: "web:80"},
+		{kind: ForwardLocal, spec: "0:web:80", listen: "localhost:0", target: "web:80"},
+		{kind: ForwardLocal, spec: "[::1]:8080:[fe80::1]:80", listen: "[::1]:8080", target: "[fe80::1]:80"},
+		{kind: ForwardRemote, spec: "8080:localhost:3000", listen: "localhost:8080", target: "localhost:3000"},
+		{kind: ForwardRemote, spec: "*:8080:localhost:3000", listen: ":8080", target: "localhost:3000"},
+		{kind: ForwardDynamic, spec: "1080", listen: "localhost:1080"},
+		{kind: ForwardDynamic, spec: "*:1080", listen: ":1080"},
+		{kind: ForwardDynamic, spec: "[::]:1080", listen: "[::]:1080"},
+		{kind: ForwardLocal, spec: "8080", err: true},
+		{kind: ForwardLocal, spec: "8080:web", err: true},
+		{kind: ForwardLocal, spec: "http:web:80", err: true},
+		{kind: ForwardLocal, spec: "8080:web:0", err: true},
+		{kind: ForwardLocal, spec: "8080::80", err: true},
+		{kind: ForwardLocal, spec: "70000:web:80", err: true},
+		{kind: ForwardLocal, spec: "a:b:8080:web:80", err: true},
+		{kind: ForwardRemote, spec: "8080:web:-1", err: true},
+		{kind: ForwardDynamic, spec: "1080:web:80", err: true},
+		{kind: ForwardDynamic, spec: "", err: true},
+	}
+
+	for _, test := range tests {
+		t.Run("-"+test.kind+" "+test.spec, func(t *testing.T) {
+			forward, err := parseForward(test.kind, test.spec)
+			if test.err {
+				if err == nil {
+					t.Errorf("expected an error, got %+v", forward)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if forward.listen != test.listen || forward.target != test.target {
+				t.Errorf(
+					"listen %q, target %q, want %q, %q",
+					forward.listen,
+					forward.target,
+					test.listen,
+					test.target,
+				)
+			}
+		})
+	}
+}
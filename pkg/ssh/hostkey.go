@@ -0,0 +1,389 @@
+package ssh
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/skevetter/devpod-provider-ssh/pkg/options"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+var errHostKeyFetched = errors.New("host key fetched")
+
+// probeHostKeyAlgorithms are tried one after another when looking for the
+// host key that matches HOST_KEY_FINGERPRINT.
+var probeHostKeyAlgorithms = []string{
+	ssh.KeyAlgoED25519,
+	ssh.KeyAlgoECDSA256,
+	ssh.KeyAlgoECDSA384,
+	ssh.KeyAlgoECDSA521,
+	ssh.KeyAlgoRSASHA512,
+}
+
+// newHostKeyCallback returns the host key verification of the builtin client.
+// It follows the same rules as the flags returned by getHostKeyFlags.
+func newHostKeyCallback(
+	provider *SSHProvider,
+	config *hostConfig,
+	dial dialFunc,
+) (ssh.HostKeyCallback, []string, error) {
+	if config.HostKeyFingerprint != "" {
+		key, err := pinnedHostKey(config, dial)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		return ssh.FixedHostKey(key), hostKeyAlgorithms(key), nil
+	}
+
+	if provider.Config.StrictHostKeyChecking == options.StrictHostKeyCheckingNo {
+		//nolint:gosec // G106: host key checking was disabled by the user
+		return ssh.InsecureIgnoreHostKey(), nil, nil
+	}
+
+	userFiles, err := knownHostsFiles(provider, config)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	existing := []string{}
+	for _, file := range append(userFiles, config.GlobalKnownHostsFiles...) {
+		if _, err := os.Stat(file); err == nil {
+			existing = append(existing, file)
+		}
+	}
+
+	callback, err := knownhosts.New(existing...)
+	if err != nil {
+		return nil, nil, fmt.Errorf("read known hosts: %w", err)
+	}
+
+	algorithms, err := knownHostKeyAlgorithms(config, callback)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return func(hostname string, remote net.Addr, key ssh.PublicKey) error {
+		err := callback(hostname, remote, key)
+		if err == nil {
+			return nil
+		}
+
+		keyErr := &knownhosts.KeyError{}
+		if errors.As(err, &keyErr) && len(keyErr.Want) == 0 &&
+			provider.Config.StrictHostKeyChecking == options.StrictHostKeyCheckingAcceptNew {
+			return addKnownHost(provider, userFiles[0], hostname, key)
+		}
+
+		return hostKeyError(hostname, key, err)
+	}, algorithms, nil
+}
+
+// probeKey is a host key that is in no known_hosts file, checking it returns
+// all keys known for a host.
+type probeKey struct{}
+
+func (probeKey) Type() string { return "probe" }
+
+func (probeKey) Marshal() []byte { return []byte("probe") }
+
+func (probeKey) Verify([]byte, *ssh.Signature) error { return errProbeKey }
+
+var errProbeKey = errors.New("probe key can't verify signatures")
+
+// knownHostKeyAlgorithms returns the host key algorithms of config with the
+// ones of the keys known for the host first. Otherwise a host that offers an
+// ECDSA key first fails verification if only its ED25519 key is known. Like
+// OpenSSH this is only done if HostKeyAlgorithms is unset or only adds or
+// removes algorithms.
+func knownHostKeyAlgorithms(config *hostConfig, callback ssh.HostKeyCallback) ([]string, error) {
+	algorithms, err := configHostKeyAlgorithms(config)
+	if err != nil {
+		return nil, err
+	}
+	spec := config.HostKeyAlgorithms
+	if spec != "" && spec[0] != '+' && spec[0] != '-' {
+		return algorithms, nil
+	}
+	if algorithms == nil {
+		algorithms = ssh.SupportedAlgorithms().HostKeys
+	}
+
+	keyErr := &knownhosts.KeyError{}
+	address := net.JoinHostPort(config.Hostname, config.Port)
+	if !errors.As(callback(address, &net.TCPAddr{}, probeKey{}), &keyErr) {
+		return algorithms, nil
+	}
+
+	known := []string{}
+	for _, want := range keyErr.Want {
+		known = append(known, want.Key.Type())
+	}
+
+	first := []string{}
+	rest := []string{}
+	for _, algorithm := range algorithms {
+		if slices.Contains(known, hostKeyType(algorithm)) {
+			first = append(first, algorithm)
+		} else {
+			rest = append(rest, algorithm)
+		}
+	}
+
+	return append(first, rest...), nil
+}
+
+// hostKeyType returns the type of the keys the host key algorithm is used
+// with.
+func hostKeyType(algorithm string) string {
+	if algorithm == ssh.KeyAlgoRSASHA256 || algorithm == ssh.KeyAlgoRSASHA512 {
+		return ssh.KeyAlgoRSA
+	}
+
+	return algorithm
+}
+
+// getHostKeyFlags returns the ssh and scp flags for host key verification.
+func getHostKeyFlags(provider *SSHProvider) ([]string, error) {
+	fingerprint := provider.Config.HostKeyFingerprint
+	if fingerprint != "" {
+		key := cachedHostKey(fingerprint)
+		if key == nil {
+			var err error
+			key, err = fetchPinnedHostKey(provider)
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		return []string{
+			"-oStrictHostKeyChecking=yes",
+			"-oUserKnownHostsFile=" + pinnedKnownHostsFile(fingerprint),
+			"-oHostKeyAlgorithms=" + strings.Join(hostKeyAlgorithms(key), ","),
+		}, nil
+	}
+
+	result := []string{"-oStrictHostKeyChecking=" + provider.Config.StrictHostKeyChecking}
+	if provider.Config.KnownHostsFile != "" {
+		result = append(result, "-oUserKnownHostsFile="+provider.Config.KnownHostsFile)
+	}
+
+	return result, nil
+}
+
+func fetchPinnedHostKey(provider *SSHProvider) (ssh.PublicKey, error) {
+	config, err := resolveConfig(provider)
+	if err != nil {
+		return nil, err
+	}
+
+	dial, jump, err := dialJumpHosts(provider, config)
+	if err != nil {
+		return nil, err
+	}
+	defer closeClient(jump)
+
+	return pinnedHostKey(config, dial)
+}
+
+func knownHostsFiles(provider *SSHProvider, config *hostConfig) ([]string, error) {
+	files := config.UserKnownHostsFiles
+	if provider.Config.KnownHostsFile != "" {
+		files = []string{provider.Config.KnownHostsFile}
+	}
+	if len(files) == 0 {
+		files = []string{"~/.ssh/known_hosts"}
+	}
+
+	result := []string{}
+	for _, file := range files {
+		abs, err := expandPath(file)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, abs)
+	}
+
+	return result, nil
+}
+
+func addKnownHost(provider *SSHProvider, file string, hostname string, key ssh.PublicKey) error {
+	err := os.MkdirAll(filepath.Dir(file), 0o700)
+	if err != nil {
+		return fmt.Errorf("create known hosts directory: %w", err)
+	}
+
+	// #nosec G304 -- file is the user's known hosts file
+	f, err := os.OpenFile(file, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o600)
+	if err != nil {
+		return fmt.Errorf("open known hosts file: %w", err)
+	}
+	defer func() { _ = f.Close() }()
+
+	address := knownhosts.Normalize(hostname)
+	_, err = f.WriteString(knownhosts.Line([]string{address}, key) + "\n")
+	if err != nil {
+		return fmt.Errorf("write known hosts file: %w", err)
+	}
+
+	provider.Log.Warnf(
+		"Permanently added '%s' (%s) to the list of known hosts.",
+		address,
+		key.Type(),
+	)
+	return nil
+}
+
+func hostKeyError(hostname string, key ssh.PublicKey, err error) error {
+	keyErr := &knownhosts.KeyError{}
+	if !errors.As(err, &keyErr) {
+		return fmt.Errorf("host key verification failed for %s: %w", hostname, err)
+	}
+
+	if len(keyErr.Want) == 0 {
+		return fmt.Errorf(
+			"host key verification failed: %s key %s of %s is not in known_hosts, "+
+				"add it or set %s to accept-new",
+			key.Type(),
+			ssh.FingerprintSHA256(key),
+			hostname,
+			options.STRICT_HOST_KEY_CHECKING,
+		)
+	}
+
+	want := keyErr.Want[0]
+	return fmt.Errorf(
+		"host key verification failed: %s key %s of %s does not match the key in %s:%d, "+
+			"the remote host key has changed or someone is intercepting the connection",
+		key.Type(),
+		ssh.FingerprintSHA256(key),
+		hostname,
+		want.Filename,
+		want.Line,
+	)
+}
+
+// pinnedHostKey returns the host key matching the pinned fingerprint. The key
+// is cached in a provider owned known hosts file so it is only fetched once.
+func pinnedHostKey(config *hostConfig, dial dialFunc) (ssh.PublicKey, error) {
+	key := cachedHostKey(config.HostKeyFingerprint)
+	if key != nil {
+		return key, nil
+	}
+
+	key, err := fetchHostKey(config, dial)
+	if err != nil {
+		return nil, err
+	}
+
+	file := pinnedKnownHostsFile(config.HostKeyFingerprint)
+	err = os.MkdirAll(filepath.Dir(file), 0o700)
+	if err != nil {
+		return nil, fmt.Errorf("create known hosts directory: %w", err)
+	}
+	err = os.WriteFile(file, []byte(knownhosts.Line([]string{"*"}, key)+"\n"), 0o600)
+	if err != nil {
+		return nil, fmt.Errorf("write known hosts file: %w", err)
+	}
+
+	return key, nil
+}
+
+func cachedHostKey(fingerprint string) ssh.PublicKey {
+	// #nosec G304 -- file is derived from the user cache directory
+	data, err := os.ReadFile(pinnedKnownHostsFile(fingerprint))
+	if err != nil {
+		return nil
+	}
+
+	_, _, key, _, _, err := ssh.ParseKnownHosts(data)
+	if err != nil || !fingerprintMatches(key, fingerprint) {
+		return nil
+	}
+
+	return key
+}
+
+func pinnedKnownHostsFile(fingerprint string) string {
+	sum := sha256.Sum256([]byte(fingerprint))
+	return filepath.Join(cacheDir(), "known_hosts-"+hex.EncodeToString(sum[:8]))
+}
+
+// fetchHostKey connects to the host once per host key algorithm until it
+// finds the key matching the pinned fingerprint.
+func fetchHostKey(config *hostConfig, dial dialFunc) (ssh.PublicKey, error) {
+	crypto, err := cryptoConfig(config)
+	if err != nil {
+		return nil, err
+	}
+
+	addr := net.JoinHostPort(config.Hostname, config.Port)
+	offered := []string{}
+	for _, algorithm := range probeHostKeyAlgorithms {
+		var hostKey ssh.PublicKey
+		clientConfig := &ssh.ClientConfig{
+			Config:            crypto,
+			User:              config.User,
+			HostKeyAlgorithms: hostKeyAlgorithmsFor(algorithm),
+			HostKeyCallback: func(_ string, _ net.Addr, key ssh.PublicKey) error {
+				hostKey = key
+				return errHostKeyFetched
+			},
+		}
+
+		conn, err := dial("tcp", addr)
+		if err != nil {
+			return nil, fmt.Errorf("fetch host key of %s: %w", addr, err)
+		}
+		_ = conn.SetDeadline(time.Now().Add(time.Second * 10))
+		_, _, _, _ = ssh.NewClientConn(conn, addr, clientConfig)
+		_ = conn.Close()
+		if hostKey == nil {
+			continue
+		}
+
+		if fingerprintMatches(hostKey, config.HostKeyFingerprint) {
+			return hostKey, nil
+		}
+		offered = append(offered, ssh.FingerprintSHA256(hostKey))
+	}
+
+	return nil, fmt.Errorf(
+		"host key verification failed: no host key of %s matches %s %s, the host offered %s",
+		addr,
+		options.HOST_KEY_FINGERPRINT,
+		config.HostKeyFingerprint,
+		strings.Join(offered, ", "),
+	)
+}
+
+func fingerprintMatches(key ssh.PublicKey, fingerprint string) bool {
+	fingerprint = strings.TrimSpace(fingerprint)
+	if md5, ok := strings.CutPrefix(fingerprint, "MD5:"); ok {
+		return strings.EqualFold(ssh.FingerprintLegacyMD5(key), md5)
+	}
+
+	fingerprint = strings.TrimRight(strings.TrimPrefix(fingerprint, "SHA256:"), "=")
+	return ssh.FingerprintSHA256(key) == "SHA256:"+fingerprint
+}
+
+func hostKeyAlgorithms(key ssh.PublicKey) []string {
+	return hostKeyAlgorithmsFor(key.Type())
+}
+
+func hostKeyAlgorithmsFor(keyType string) []string {
+	if keyType == ssh.KeyAlgoRSA || keyType == ssh.KeyAlgoRSASHA512 {
+		return []string{ssh.KeyAlgoRSASHA512, ssh.KeyAlgoRSASHA256, ssh.KeyAlgoRSA}
+	}
+
+	return []string{keyType}
+}
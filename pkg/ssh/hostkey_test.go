@@ -0,0 +1,44 @@
+package ssh
+
+import (
+	"crypto/ed25519"
+	"testing"
+
+	"golang.org/x/crypto/ssh"
+)
+
+func TestFingerprintMatches(t *testing.T) {
+	// the ed25519 key of an all zero seed
+	key, err := ssh.NewPublicKey(ed25519.NewKeyFromSeed(make([]byte, ed25519.SeedSize)).Public())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		fingerprint string
+		want        bool
+	}{
+		{fingerprint: "SHA256:tAXFyTXI8xtDaujAEcwJslAYc9/6FKcUkd2Lw0xDhPo", want: true},
+		{fingerprint: "tAXFyTXI8xtDaujAEcwJslAYc9/6FKcUkd2Lw0xDhPo", want: true},
+		{fingerprint: "SHA256:tAXFyTXI8xtDaujAEcwJslAYc9/6FKcUkd2Lw0xDhPo=", want: true},
+		{fingerprint: " SHA256:tAXFyTXI8xtDaujAEcwJslAYc9/6FKcUkd2Lw0xDhPo\n", want: true},
+		{fingerprint: "MD5:27:c6:3f:84:be:6f:a4:5e:eb:f9:4d:6e:bd:c8:bb:48", want: true},
+		{fingerprint: "MD5:27:C6:3F:84:BE:6F:A4:5E:EB:F9:4D:6E:BD:C8:BB:48", want: true},
+		{fingerprint: "SHA256:tAXFyTXI8xtDaujAEcwJslAYc9/6FKcUkd2Lw0xDhPO", want: false},
+		{fingerprint: "SHA256:mXhe4VT8IZgX1fUCWPH5SZ3xupG1AvwouipH1zOKCA0", want: false},
+		{fingerprint: "MD5:52:24:ba:4b:01:38:03:bc:1c:34:79:59:a4:7a:a5:1f", want: false},
+		{fingerprint: "27:c6:3f:84:be:6f:a4:5e:eb:f9:4d:6e:bd:c8:bb:48", want: false},
+		{fingerprint: "SHA256:", want: false},
+		{fingerprint: "MD5:", want: false},
+		{fingerprint: "", want: false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.fingerprint, func(t *testing.T) {
+			got := fingerprintMatches(key, test.fingerprint)
+			if got != test.want {
+				t.Errorf("fingerprintMatches = %t, want %t", got, test.want)
+			}
+		})
+	}
+}
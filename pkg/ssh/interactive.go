@@ -0,0 +1,139 @@
+package ssh
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+
+	"github.com/skevetter/devpod-provider-ssh/pkg/options"
+	"golang.org/x/crypto/ssh"
+)
+
+// askpassEnv marks the provider binary as the SSH_ASKPASS program of the ssh
+// binary, see IsAskpass.
+const askpassEnv = "DEVPOD_PROVIDER_SSH_ASKPASS"
+
+// passwordPromptEnv passes the prompt to PASSWORD_COMMAND.
+const passwordPromptEnv = "PASSWORD_PROMPT"
+
+// interactiveAuth returns the keyboard-interactive and password
+// authentication of the builtin client. The answers are never logged.
+func interactiveAuth(provider *SSHProvider, config *hostConfig) []ssh.AuthMethod {
+	return []ssh.AuthMethod{
+		ssh.KeyboardInteractive(func(
+			_ string,
+			instruction string,
+			questions []string,
+			_ []bool,
+		) ([]string, error) {
+			if instruction != "" {
+				provider.Log.Info(instruction)
+			}
+
+			answers := make([]string, len(questions))
+			for i, question := range questions {
+				answer, err := askSecret(provider, question)
+				if err != nil {
+					return nil, err
+				}
+				answers[i] = answer
+			}
+
+			return answers, nil
+		}),
+		ssh.PasswordCallback(func() (string, error) {
+			return askSecret(provider, fmt.Sprintf("%s@%s's password: ", config.User, config.Hostname))
+		}),
+	}
+}
+
+// askSecret answers prompt with PASSWORD_COMMAND or the SSH_ASKPASS program.
+func askSecret(provider *SSHProvider, prompt string) (string, error) {
+	if provider.Config.PasswordCommand != "" {
+		answer, err := runSecretCommand(
+			provider.Config.PasswordCommand,
+			passwordPromptEnv+"="+prompt,
+		)
+		if err != nil {
+			return "", fmt.Errorf("run %s: %w", options.PASSWORD_COMMAND, err)
+		}
+
+		return answer, nil
+	}
+
+	answer, err := askpass(prompt)
+	if errors.Is(err, errNoAskpass) {
+		return "", fmt.Errorf(
+			"no answer for %q, set %s or SSH_ASKPASS",
+			strings.TrimSpace(prompt),
+			options.PASSWORD_COMMAND,
+		)
+	}
+
+	return answer, err
+}
+
+// sshCommand returns the ssh or scp command with args, it is terminated when
+// ctx is canceled. With interactive authentication it answers prompts through
+// PASSWORD_COMMAND or SSH_ASKPASS.
+func sshCommand(ctx context.Context, provider *SSHProvider, name string, args ...string) *exec.Cmd {
+	// #nosec G204 -- the arguments come from the provider options
+	cmd := exec.CommandContext(ctx, name, args...)
+	terminateOnCancel(ctx, cmd)
+	if !provider.Config.AllowInteractiveAuth {
+		return cmd
+	}
+
+	cmd.Env = os.Environ()
+	if provider.Config.PasswordCommand == "" {
+		// ssh only prefers SSH_ASKPASS with a display, the builtin client
+		// always does
+		if os.Getenv("SSH_ASKPASS") != "" && os.Getenv("SSH_ASKPASS_REQUIRE") == "" {
+			cmd.Env = append(cmd.Env, "SSH_ASKPASS_REQUIRE=force")
+		}
+		return cmd
+	}
+
+	executable, err := os.Executable()
+	if err != nil {
+		provider.Log.Warnf("%s can't be used by ssh: %v", options.PASSWORD_COMMAND, err)
+		return cmd
+	}
+
+	cmd.Env = append(
+		cmd.Env,
+		"SSH_ASKPASS="+executable,
+		"SSH_ASKPASS_REQUIRE=force",
+		askpassEnv+"=true",
+	)
+	return cmd
+}
+
+// IsAskpass returns whether the provider was started by the ssh binary as
+// its SSH_ASKPASS program with args. ssh passes the prompt as only argument,
+// the ProxyCommand inherits the environment as well but gets more.
+func IsAskpass(args []string) bool {
+	return os.Getenv(askpassEnv) == "true" && len(args) == 1
+}
+
+// Askpass answers the prompt of the ssh binary in args with PASSWORD_COMMAND.
+func Askpass(args []string) error {
+	prompt := ""
+	if len(args) > 0 {
+		prompt = args[0]
+	}
+
+	answer, err := runSecretCommand(
+		os.Getenv(options.PASSWORD_COMMAND),
+		passwordPromptEnv+"="+prompt,
+	)
+	if err != nil {
+		return fmt.Errorf("run %s: %w", options.PASSWORD_COMMAND, err)
+	}
+
+	_, err = fmt.Fprintln(os.Stdout, answer)
+	return err
+}
@@ -0,0 +1,137 @@
+package ssh
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/skevetter/devpod-provider-ssh/pkg/options"
+	"golang.org/x/crypto/ssh"
+)
+
+// keepAliveSettings returns the keepalive interval and ServerAliveCountMax.
+// The options take precedence over the ssh config.
+func keepAliveSettings(provider *SSHProvider, config *hostConfig) (time.Duration, int, error) {
+	interval := provider.Config.ServerAliveInterval
+	if interval == "" {
+		interval = config.ServerAliveInterval
+	}
+	countMax := provider.Config.ServerAliveCountMax
+	if countMax == "" {
+		countMax = config.ServerAliveCountMax
+	}
+
+	duration := time.Duration(0)
+	if interval != "" {
+		var err error
+		duration, err = options.ParseSeconds(interval)
+		if err != nil {
+			return 0, 0, fmt.Errorf("invalid ServerAliveInterval: %w", err)
+		}
+		duration = roundUpSeconds(duration)
+	}
+
+	count := 3
+	if countMax != "" {
+		var err error
+		count, err = strconv.Atoi(countMax)
+		if err != nil || count < 0 {
+			return 0, 0, fmt.Errorf("invalid ServerAliveCountMax %q", countMax)
+		}
+	}
+
+	return duration, count, nil
+}
+
+// getKeepAliveFlags returns the ssh and scp flags for the keepalive options.
+func getKeepAliveFlags(provider *SSHProvider) []string {
+	result := []string{}
+	if provider.Config.ServerAliveInterval != "" {
+		interval, _ := options.ParseSeconds(provider.Config.ServerAliveInterval)
+		interval = roundUpSeconds(interval)
+		result = append(result, fmt.Sprintf("-oServerAliveInterval=%d", int(interval.Seconds())))
+	}
+	if provider.Config.ServerAliveCountMax != "" {
+		result = append(result, "-oServerAliveCountMax="+provider.Config.ServerAliveCountMax)
+	}
+
+	return result
+}
+
+// keepAlive sends a keepalive request every interval while the client is
+// open. Like ssh it closes the client once more than countMax intervals in a
+// row ended without a reply, with a countMax of 0 a single one is enough.
+// Closing the client fails its sessions.
+func keepAlive(provider *SSHProvider, client *ssh.Client, interval time.Duration, countMax int) {
+	if interval <= 0 {
+		return
+	}
+
+	closed := make(chan struct{})
+	go func() {
+		_ = client.Wait()
+		close(closed)
+	}()
+
+	go func() {
+		lastReply := time.Now()
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		// only one request is in flight at a time, SendRequest blocks until
+		// the reply arrives which never happens if the peer is gone
+		replies := make(chan error, 1)
+		pending := false
+		missed := 0
+		for {
+			select {
+			case <-closed:
+				return
+			case err := <-replies:
+				if err != nil {
+					return
+				}
+				pending = false
+				missed = 0
+				lastReply = time.Now()
+			case <-ticker.C:
+				missed++
+				if missed > countMax {
+					provider.setConnectionLost(client, fmt.Errorf(
+						"connection to %s lost: no reply to keepalive requests for %s",
+						client.RemoteAddr(),
+						time.Since(lastReply).Round(time.Second),
+					))
+					_ = client.Close()
+					return
+				}
+
+				if !pending {
+					pending = true
+					go func() {
+						_, _, err := client.SendRequest("keepalive@openssh.com", true, nil)
+						replies <- err
+					}()
+				}
+			}
+		}
+	}()
+}
+
+func (provider *SSHProvider) setConnectionLost(client *ssh.Client, err error) {
+	provider.lostMu.Lock()
+	defer provider.lostMu.Unlock()
+
+	if provider.client == client {
+		provider.lostErr = err
+	}
+}
+
+// connectionLost returns why the keepalive closed the connection of the
+// builtin client, if it did.
+func (provider *SSHProvider) connectionLost() error {
+	provider.lostMu.Lock()
+	defer provider.lostMu.Unlock()
+
+	return provider.lostErr
+}
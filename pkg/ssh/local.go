@@ -0,0 +1,60 @@
+package ssh
+
+import (
+	"context"
+	"net"
+	"os"
+	"os/exec"
+	"os/user"
+
+	"github.com/skevetter/devpod-provider-ssh/pkg/options"
+)
+
+// useLocalTransport returns whether commands run on the local host instead of
+// over ssh. With TRANSPORT=auto that is the case if the host is a loopback
+// address on the default port, reached directly as the local user.
+func useLocalTransport(provider *SSHProvider) bool {
+	if provider.Config.Transport != options.TransportAuto {
+		return provider.Config.Transport == options.TransportLocal
+	}
+	if provider.transportChecked {
+		return provider.localTransport
+	}
+	provider.transportChecked = true
+
+	config, err := resolveConfig(provider)
+	if err != nil {
+		provider.Log.Debugf("use ssh transport: %v", err)
+		return false
+	}
+
+	current, err := user.Current()
+	if err != nil {
+		provider.Log.Debugf("use ssh transport: %v", err)
+		return false
+	}
+
+	ip := net.ParseIP(config.Hostname)
+	provider.localTransport = (config.Hostname == "localhost" || (ip != nil && ip.IsLoopback())) &&
+		config.Port == "22" &&
+		config.User == current.Username &&
+		len(jumpHosts(provider, config)) == 0 &&
+		(config.ProxyCommand == "" || config.ProxyCommand == "none")
+	if provider.localTransport {
+		provider.Log.Debugf("%s is the local host, run commands without ssh", provider.Config.Host)
+	}
+
+	return provider.localTransport
+}
+
+// runLocalCommand runs command with the local /bin/sh, the same way the ssh
+// server would run it with the login shell of the user.
+func runLocalCommand(ctx context.Context, command string, cmdIO commandIO) error {
+	cmd := exec.CommandContext(ctx, "/bin/sh", "-c", command)
+	terminateOnCancel(ctx, cmd)
+	cmd.Stdin = cmdIO.stdin
+	cmd.Stdout = cmdIO.output
+	cmd.Stderr = os.Stderr
+
+	return canceled(ctx, cmd.Run())
+}
@@ -0,0 +1,152 @@
+package ssh
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path"
+	"strings"
+
+	"github.com/kballard/go-shellquote"
+	"github.com/skevetter/devpod-provider-ssh/pkg/options"
+)
+
+// containerLabel is set by DevPod on every workspace container, its value is
+// the UID of the workspace or, for workspaces with a legacy UID, its ID.
+const containerLabel = "dev.containers.id"
+
+// workspacePrefix is the prefix of the files in the machine directory that
+// record the workspaces that ran on the machine.
+const workspacePrefix = "workspace-"
+
+// machineDir returns the directory of the machine on the host, next to the
+// agent binary.
+func machineDir(provider *SSHProvider) (string, error) {
+	err := checkID(options.MACHINE_ID, provider.Config.MachineID)
+	if err != nil {
+		return "", err
+	}
+
+	return path.Join(path.Dir(provider.Config.AgentPath), "machines", provider.Config.MachineID), nil
+}
+
+// checkID makes sure id can be used as a single path element, so the paths
+// built from it stay inside the machines directory.
+func checkID(name string, id string) error {
+	if id == "" {
+		return errors.New(name + " is not set")
+	}
+	if id == "." || id == ".." || strings.ContainsAny(id, "/\\") {
+		return fmt.Errorf("%s %q is not a valid ID", name, id)
+	}
+
+	return nil
+}
+
+// recordWorkspace records the workspace of a command in the machine
+// directory, so Delete only removes the containers of the workspaces of this
+// machine. The file is named after the UID of the workspace and holds its ID.
+// Commands that don't run for a workspace of a machine aren't recorded, and
+// failing to record one doesn't fail the command.
+func recordWorkspace(ctx context.Context, provider *SSHProvider) {
+	dir, err := machineDir(provider)
+	if err != nil || checkID(options.WORKSPACE_UID, provider.Config.WorkspaceUID) != nil {
+		return
+	}
+
+	file := shellquote.Join(path.Join(dir, workspacePrefix+provider.Config.WorkspaceUID))
+	script := fmt.Sprintf(
+		"{ [ -e %s ] || printf '%%s\\n' %s > %s; } 2>/dev/null",
+		file,
+		shellquote.Join(provider.Config.WorkspaceID),
+		file,
+	)
+	err = runSSHCommand(ctx, provider, script, commandIO{})
+	if err != nil {
+		provider.Log.Debugf("record workspace %s: %v", provider.Config.WorkspaceUID, err)
+	}
+}
+
+// Create prepares the directory of the machine on the host. It is owned by
+// the ssh user and only accessible by it, sudo is used if the user can't
+// create it.
+func Create(ctx context.Context, provider *SSHProvider) error {
+	dir, err := machineDir(provider)
+	if err != nil {
+		return err
+	}
+
+	quoted := shellquote.Join(dir)
+	script := fmt.Sprintf(
+		"if ! { mkdir -p %s && [ -O %s ]; } 2>/dev/null; then "+
+			"sudo -n mkdir -p %s && sudo -n chown \"$(id -u):$(id -g)\" %s || exit 1; fi; "+
+			"chmod 700 %s",
+		quoted, quoted, quoted, quoted, quoted,
+	)
+	err = execSSHCommand(ctx, provider, script, nil)
+	if err != nil {
+		if ctx.Err() != nil {
+			return context.Cause(ctx)
+		}
+		return fmt.Errorf("create %s on %s: %w", dir, provider.Config.Host, err)
+	}
+
+	return nil
+}
+
+// Delete removes the directory of the machine and the leftover command
+// scripts of the ssh user from the host. The agent binary is shared by the
+// machines on the host, it is removed with the last machine directory. Unless
+// keepContainers is set the containers of the workspaces recorded in the
+// directory are removed as well, containers of other machines are left alone.
+func Delete(ctx context.Context, provider *SSHProvider, keepContainers bool) error {
+	dir, err := machineDir(provider)
+	if err != nil {
+		return err
+	}
+
+	quoted := shellquote.Join(dir)
+	machines := shellquote.Join(path.Dir(dir))
+	agent := shellquote.Join(provider.Config.AgentPath)
+	script := fmt.Sprintf(
+		"rm -rf %s 2>/dev/null || sudo -n rm -rf %s || exit 1; "+
+			"find /tmp -maxdepth 1 -name 'devpod-command-*' -user \"$(id -u)\" "+
+			"-exec rm -f {} + 2>/dev/null; "+
+			"if rmdir %s 2>/dev/null || sudo -n rmdir %s 2>/dev/null; then "+
+			"rm -f %s 2>/dev/null || sudo -n rm -f %s; fi",
+		quoted, quoted, machines, machines, agent, agent,
+	)
+	if !keepContainers {
+		script = deleteContainersScript(provider, dir) + " " + script
+	}
+
+	err = execSSHCommand(ctx, provider, script, nil)
+	if err != nil {
+		if ctx.Err() != nil {
+			return context.Cause(ctx)
+		}
+		return fmt.Errorf("delete %s on %s: %w", dir, provider.Config.Host, err)
+	}
+
+	return nil
+}
+
+// deleteContainersScript removes the containers of the workspaces recorded in
+// dir, with sudo if the ssh user has no access to the docker daemon. The
+// containers are looked up by both the UID and the ID of the workspace.
+func deleteContainersScript(provider *SSHProvider, dir string) string {
+	docker := provider.Config.DockerPath
+	return fmt.Sprintf(
+		"docker=%s; for file in %s/%s*; do [ -e \"$file\" ] || continue; "+
+			"$docker ps >/dev/null 2>&1 || docker=\"sudo -n %s\"; "+
+			"for id in \"${file##*/%s}\" $(cat \"$file\"); do "+
+			"ids=$($docker ps -aq --filter \"label=%s=$id\") || exit 1; "+
+			"[ -z \"$ids\" ] || $docker rm -f $ids >/dev/null || exit 1; done; done;",
+		shellquote.Join(docker),
+		shellquote.Join(dir),
+		workspacePrefix,
+		shellquote.Join(docker),
+		workspacePrefix,
+		containerLabel,
+	)
+}
@@ -0,0 +1,140 @@
+package ssh
+
+import (
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+
+	"github.com/skevetter/devpod-provider-ssh/pkg/options"
+	"golang.org/x/crypto/ssh"
+)
+
+// encryptedSigner is a passphrase protected identity. The key is decrypted
+// the first time it is used, so the passphrase is only asked for keys the
+// server accepts.
+type encryptedSigner struct {
+	provider  *SSHProvider
+	path      string
+	key       []byte
+	publicKey ssh.PublicKey
+
+	once   sync.Once
+	signer ssh.AlgorithmSigner
+	err    error
+}
+
+var _ ssh.AlgorithmSigner = &encryptedSigner{}
+
+func newEncryptedSigner(
+	provider *SSHProvider,
+	path string,
+	key []byte,
+	publicKey ssh.PublicKey,
+) (ssh.Signer, error) {
+	if publicKey == nil {
+		publicKey, _ = readPublicKey(path + ".pub")
+	}
+
+	signer := &encryptedSigner{
+		provider:  provider,
+		path:      path,
+		key:       key,
+		publicKey: publicKey,
+	}
+
+	// without the public key we can't defer the decryption
+	if publicKey == nil {
+		err := signer.decrypt()
+		if err != nil {
+			return nil, err
+		}
+		signer.publicKey = signer.signer.PublicKey()
+	}
+
+	return signer, nil
+}
+
+func (s *encryptedSigner) PublicKey() ssh.PublicKey {
+	return s.publicKey
+}
+
+func (s *encryptedSigner) Sign(rand io.Reader, data []byte) (*ssh.Signature, error) {
+	err := s.decrypt()
+	if err != nil {
+		return nil, err
+	}
+
+	return s.signer.Sign(rand, data)
+}
+
+func (s *encryptedSigner) SignWithAlgorithm(
+	rand io.Reader,
+	data []byte,
+	algorithm string,
+) (*ssh.Signature, error) {
+	err := s.decrypt()
+	if err != nil {
+		return nil, err
+	}
+
+	return s.signer.SignWithAlgorithm(rand, data, algorithm)
+}
+
+func (s *encryptedSigner) decrypt() error {
+	s.once.Do(func() {
+		passphrase, err := keyPassphrase(s.provider, s.path)
+		if err != nil {
+			s.err = err
+			return
+		}
+
+		signer, err := ssh.ParsePrivateKeyWithPassphrase(s.key, []byte(passphrase))
+		if errors.Is(err, x509.IncorrectPasswordError) {
+			s.err = fmt.Errorf("decrypt identityfile %s: incorrect passphrase", s.path)
+			return
+		} else if err != nil {
+			s.err = fmt.Errorf("decrypt identityfile %s: %w", s.path, err)
+			return
+		}
+
+		algorithmSigner, ok := signer.(ssh.AlgorithmSigner)
+		if !ok {
+			s.err = fmt.Errorf("decrypt identityfile %s: unsupported key type", s.path)
+			return
+		}
+		s.signer = algorithmSigner
+	})
+
+	return s.err
+}
+
+// keyPassphrase returns the passphrase for the identity file at path. It uses
+// KEY_PASSPHRASE, KEY_PASSPHRASE_COMMAND and SSH_ASKPASS in that order.
+func keyPassphrase(provider *SSHProvider, path string) (string, error) {
+	if provider.Config.KeyPassphrase != "" {
+		return provider.Config.KeyPassphrase, nil
+	}
+
+	if provider.Config.KeyPassphraseCommand != "" {
+		passphrase, err := runSecretCommand(provider.Config.KeyPassphraseCommand)
+		if err != nil {
+			return "", fmt.Errorf("run %s: %w", options.KEY_PASSPHRASE_COMMAND, err)
+		}
+		return passphrase, nil
+	}
+
+	passphrase, err := askpass(fmt.Sprintf("Enter passphrase for key '%s': ", path))
+	if errors.Is(err, errNoAskpass) {
+		return "", fmt.Errorf(
+			"identityfile %s is encrypted and no passphrase was provided, "+
+				"set %s, %s or SSH_ASKPASS, or add the key to your ssh agent",
+			path,
+			options.KEY_PASSPHRASE,
+			options.KEY_PASSPHRASE_COMMAND,
+		)
+	}
+
+	return passphrase, err
+}
@@ -0,0 +1,129 @@
+package ssh
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"os/exec"
+	"strings"
+	"time"
+
+	"github.com/skevetter/devpod-provider-ssh/pkg/options"
+	"golang.org/x/crypto/ssh"
+)
+
+// wakeInterval is the delay between two attempts to reach a starting host.
+const wakeInterval = time.Second * 5
+
+// stopStarted is printed on the host before STOP_COMMAND runs, a connection
+// that closes after it was printed was closed by the command.
+const stopStarted = "devpod-stop-started"
+
+// Start wakes the host with a Wake-on-LAN magic packet and waits until it
+// can be reached over ssh. The packet is sent again before every attempt in
+// case it got lost. Without MAC_ADDRESS the host is expected to be running
+// and nothing is done.
+func Start(ctx context.Context, provider *SSHProvider) error {
+	if len(provider.Config.MACAddress) == 0 {
+		provider.Log.Debugf("%s is not set, skip waking the host", options.MAC_ADDRESS)
+		return nil
+	}
+	provider.Config.ConnectRetries = 0
+
+	deadline := time.Now().Add(provider.Config.StartTimeout)
+	for {
+		err := sendMagicPacket(provider.Config.MACAddress, provider.Config.WakeOnLANAddress)
+		if err != nil {
+			return fmt.Errorf(
+				"send magic packet to %s: %w",
+				provider.Config.WakeOnLANAddress,
+				err,
+			)
+		}
+
+		err = execSSHCommand(ctx, provider, "true", nil)
+		switch {
+		case err == nil:
+			return nil
+		case ctx.Err() != nil:
+			return context.Cause(ctx)
+		case !isUnreachable(err):
+			return fmt.Errorf("start %s: %w", provider.Config.Host, err)
+		case time.Now().After(deadline):
+			return fmt.Errorf(
+				"%s not reachable after %s: %v",
+				provider.Config.Host,
+				provider.Config.StartTimeout,
+				err,
+			)
+		}
+		provider.Log.Debugf("wait for %s to start: %v", provider.Config.Host, err)
+
+		select {
+		case <-ctx.Done():
+			return context.Cause(ctx)
+		case <-time.After(wakeInterval):
+		}
+	}
+}
+
+// sendMagicPacket broadcasts the Wake-on-LAN packet for mac to address, six
+// bytes 0xff followed by the MAC address repeated 16 times.
+func sendMagicPacket(mac net.HardwareAddr, address string) error {
+	packet := make([]byte, 0, 6+16*len(mac))
+	for range 6 {
+		packet = append(packet, 0xff)
+	}
+	for range 16 {
+		packet = append(packet, mac...)
+	}
+
+	// Go enables SO_BROADCAST on UDP sockets
+	conn, err := net.Dial("udp", address)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = conn.Close() }()
+
+	_, err = conn.Write(packet)
+	return err
+}
+
+// Stop suspends or powers off the host with STOP_COMMAND. Without it the host
+// keeps running, it may be shared with others. The host may close the
+// connection before the command returns, that counts as success once the
+// command started.
+func Stop(ctx context.Context, provider *SSHProvider) error {
+	if provider.Config.StopCommand == "" {
+		provider.Log.Debugf("%s is not set, skip stopping the host", options.STOP_COMMAND)
+		return nil
+	}
+
+	out := new(bytes.Buffer)
+	err := execSSHCommand(ctx, provider, "echo "+stopStarted+"; "+provider.Config.StopCommand, out)
+	if err != nil && !(isDisconnected(provider, err) && strings.Contains(out.String(), stopStarted)) {
+		if ctx.Err() != nil {
+			return context.Cause(ctx)
+		}
+		return fmt.Errorf("stop %s: %w", provider.Config.Host, err)
+	}
+
+	return nil
+}
+
+// isDisconnected returns whether err shows the connection closed while a
+// command ran, the ssh binary exits with 255 then. Failed logins exit with
+// 255 as well, so the caller has to check that the command started.
+func isDisconnected(provider *SSHProvider, err error) bool {
+	if isUnreachable(err) {
+		return false
+	}
+
+	exitMissingErr := &ssh.ExitMissingError{}
+	exitErr := &exec.ExitError{}
+	return errors.As(err, &exitMissingErr) ||
+		provider.connectionLost() != nil ||
+		(errors.As(err, &exitErr) && exitErr.ExitCode() == 255)
+}
@@ -0,0 +1,270 @@
+package ssh
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/skevetter/devpod-provider-ssh/pkg/options"
+	"golang.org/x/net/http/httpproxy"
+	"golang.org/x/net/proxy"
+)
+
+// sshProxyScheme is the scheme of a PROXY_URL that is a ssh jump host.
+const sshProxyScheme = "ssh"
+
+// proxyDialer returns the dialer for the first hop of the connection. It goes
+// through PROXY_URL unless the address is excluded by NO_PROXY. A ssh proxy
+// is a jump host, the connection to it is direct.
+func proxyDialer(provider *SSHProvider, timeout time.Duration) dialFunc {
+	direct := &net.Dialer{Timeout: timeout}
+	proxyURL := provider.Config.ProxyURL
+	if proxyURL == nil || proxyURL.Scheme == sshProxyScheme {
+		return direct.Dial
+	}
+
+	return func(network string, addr string) (net.Conn, error) {
+		if !useProxy(provider, addr) {
+			return direct.Dial(network, addr)
+		}
+
+		conn, err := dialProxy(proxyURL, network, addr, timeout)
+		if err != nil {
+			return nil, fmt.Errorf("proxy %s: %w", proxyURL.Redacted(), err)
+		}
+
+		return conn, nil
+	}
+}
+
+// proxyJump returns PROXY_URL as a jump host if it is a ssh proxy. It is
+// empty if the host has a ProxyCommand or is excluded by NO_PROXY.
+func proxyJump(provider *SSHProvider, config *hostConfig) string {
+	proxyURL := provider.Config.ProxyURL
+	if proxyURL == nil || proxyURL.Scheme != sshProxyScheme ||
+		(config.ProxyCommand != "" && config.ProxyCommand != "none") ||
+		!useProxy(provider, net.JoinHostPort(config.Hostname, config.Port)) {
+		return ""
+	}
+
+	if proxyURL.User == nil {
+		return proxyURL.Host
+	}
+	return proxyURL.User.Username() + "@" + proxyURL.Host
+}
+
+// useProxy returns whether addr is reached through PROXY_URL. It follows the
+// rules of HTTP proxies, whether NO_PROXY is set or not: localhost and
+// loopback addresses are always connected to directly, and so are the hosts
+// matched by NO_PROXY.
+func useProxy(provider *SSHProvider, addr string) bool {
+	config := &httpproxy.Config{
+		HTTPSProxy: provider.Config.ProxyURL.String(),
+		NoProxy:    provider.Config.NoProxy,
+	}
+	proxyURL, err := config.ProxyFunc()(&url.URL{Scheme: "https", Host: addr})
+	return err != nil || proxyURL != nil
+}
+
+// dialProxy connects to addr through proxyURL. The whole proxy handshake is
+// aborted after timeout, if it is set.
+func dialProxy(
+	proxyURL *url.URL,
+	network string,
+	addr string,
+	timeout time.Duration,
+) (net.Conn, error) {
+	ctx := context.Background()
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+
+	switch proxyURL.Scheme {
+	case "socks5", "socks5h":
+		return dialSOCKS5(ctx, proxyURL, network, addr)
+	default:
+		return dialHTTPConnect(ctx, proxyURL, network, addr)
+	}
+}
+
+func dialSOCKS5(ctx context.Context, proxyURL *url.URL, network string, addr string) (net.Conn, error) {
+	// socks5h leaves resolving the host to the proxy
+	if proxyURL.Scheme == "socks5" {
+		host, port, err := net.SplitHostPort(addr)
+		if err != nil {
+			return nil, err
+		}
+
+		ips, err := net.DefaultResolver.LookupIPAddr(ctx, host)
+		if err != nil {
+			return nil, err
+		}
+		addr = net.JoinHostPort(ips[0].String(), port)
+	}
+
+	var auth *proxy.Auth
+	if proxyURL.User != nil {
+		password, _ := proxyURL.User.Password()
+		auth = &proxy.Auth{User: proxyURL.User.Username(), Password: password}
+	}
+
+	dialer, err := proxy.SOCKS5("tcp", proxyURL.Host, auth, &net.Dialer{})
+	if err != nil {
+		return nil, err
+	}
+
+	return dialer.(proxy.ContextDialer).DialContext(ctx, network, addr)
+}
+
+// dialHTTPConnect opens a tunnel to addr with an HTTP CONNECT request.
+func dialHTTPConnect(ctx context.Context, proxyURL *url.URL, network string, addr string) (net.Conn, error) {
+	dialer := &net.Dialer{}
+	conn, err := dialer.DialContext(ctx, network, proxyURL.Host)
+	if err != nil {
+		return nil, err
+	}
+
+	if deadline, ok := ctx.Deadline(); ok {
+		_ = conn.SetDeadline(deadline)
+	}
+	if proxyURL.Scheme == "https" {
+		conn = tls.Client(conn, &tls.Config{
+			ServerName: proxyURL.Hostname(),
+			MinVersion: tls.VersionTLS12,
+		})
+	}
+
+	request := &http.Request{
+		Method: http.MethodConnect,
+		URL:    &url.URL{Opaque: addr},
+		Host:   addr,
+		Header: http.Header{},
+	}
+	if proxyURL.User != nil {
+		password, _ := proxyURL.User.Password()
+		credentials := proxyURL.User.Username() + ":" + password
+		request.Header.Set(
+			"Proxy-Authorization",
+			"Basic "+base64.StdEncoding.EncodeToString([]byte(credentials)),
+		)
+	}
+
+	err = request.Write(conn)
+	if err != nil {
+		_ = conn.Close()
+		return nil, err
+	}
+
+	reader := bufio.NewReader(conn)
+	response, err := http.ReadResponse(reader, request)
+	if err != nil {
+		_ = conn.Close()
+		return nil, err
+	}
+	// the body of a successful CONNECT is the tunnel, it must not be read
+	if response.StatusCode != http.StatusOK {
+		_ = conn.Close()
+		return nil, fmt.Errorf("CONNECT %s: %s", addr, response.Status)
+	}
+
+	_ = conn.SetDeadline(time.Time{})
+	if reader.Buffered() > 0 {
+		// the server already sent its banner
+		return &bufferedConn{Conn: conn, reader: reader}, nil
+	}
+
+	return conn, nil
+}
+
+// bufferedConn is a connection whose first bytes were already read into
+// reader.
+type bufferedConn struct {
+	net.Conn
+	reader *bufio.Reader
+}
+
+func (c *bufferedConn) Read(b []byte) (int, error) {
+	return c.reader.Read(b)
+}
+
+// getProxyFlags returns the ssh and scp flags that connect through PROXY_URL.
+// Like for the builtin client, a ProxyCommand or ProxyJump of the ssh config
+// takes precedence. The ssh binary can't use a proxy for jump hosts.
+func getProxyFlags(provider *SSHProvider) ([]string, error) {
+	if provider.Config.ProxyURL == nil {
+		return nil, nil
+	}
+	if provider.Config.JumpHosts != "" {
+		provider.Log.Debugf("ignore %s, the ssh binary can't use it with jump hosts", options.PROXY_URL)
+		return nil, nil
+	}
+
+	config, err := resolveConfig(provider)
+	if err != nil {
+		return nil, err
+	}
+	if config.ProxyJump != "" && config.ProxyJump != "none" {
+		return nil, nil
+	}
+
+	// a ssh proxy is a jump host, which the ssh binary supports natively
+	if jump := proxyJump(provider, config); jump != "" {
+		return []string{"-J", jump}, nil
+	}
+	if provider.Config.ProxyURL.Scheme == sshProxyScheme ||
+		(config.ProxyCommand != "" && config.ProxyCommand != "none") ||
+		!useProxy(provider, net.JoinHostPort(config.Hostname, config.Port)) {
+		return nil, nil
+	}
+
+	// OpenSSH can't speak SOCKS5 or HTTP CONNECT itself, it only reaches a
+	// host through a proxy with a ProxyCommand. The provider is used as that
+	// command, so no netcat or connect-proxy has to be installed and proxy
+	// credentials don't end up on a command line.
+	executable, err := os.Executable()
+	if err != nil {
+		return nil, fmt.Errorf("use %s with the ssh binary: %w", options.PROXY_URL, err)
+	}
+
+	// ssh expands % tokens in the ProxyCommand
+	executable = strings.ReplaceAll(executable, "%", "%%")
+	return []string{fmt.Sprintf(`-oProxyCommand="%s" proxy %%h %%p`, executable)}, nil
+}
+
+// ProxyConnect connects to host and port through PROXY_URL and copies the
+// connection from stdin and to stdout, until either side closes it.
+func ProxyConnect(provider *SSHProvider, host string, port string) error {
+	timeout, err := connectTimeout(provider, "")
+	if err != nil {
+		return err
+	}
+
+	conn, err := proxyDialer(provider, timeout)("tcp", net.JoinHostPort(host, port))
+	if err != nil {
+		return err
+	}
+	defer func() { _ = conn.Close() }()
+
+	done := make(chan error, 2)
+	go func() {
+		_, err := io.Copy(conn, os.Stdin)
+		done <- err
+	}()
+	go func() {
+		_, err := io.Copy(os.Stdout, conn)
+		done <- err
+	}()
+
+	return <-done
+}
@@ -0,0 +1,54 @@
+package ssh
+
+import (
+	"net/url"
+	"testing"
+
+	"github.com/skevetter/devpod-provider-ssh/pkg/options"
+)
+
+func TestUseProxy(t *testing.T) {
+	tests := []struct {
+		noProxy string
+		addr    string
+		want    bool
+	}{
+		{addr: "example.com:22", want: true},
+		{addr: "192.0.2.1:22", want: true},
+		{addr: "localhost:22", want: false},
+		{addr: "127.0.0.1:22", want: false},
+		{addr: "127.1.2.3:2222", want: false},
+		{addr: "[::1]:22", want: false},
+		{noProxy: ".internal", addr: "localhost:22", want: false},
+		{noProxy: ".internal", addr: "127.0.0.1:22", want: false},
+		{noProxy: ".internal", addr: "db.internal:22", want: false},
+		{noProxy: ".internal", addr: "example.com:22", want: true},
+		{noProxy: "example.com", addr: "example.com:22", want: false},
+		{noProxy: "example.com", addr: "www.example.com:22", want: false},
+		{noProxy: "example.com:2222", addr: "example.com:22", want: true},
+		{noProxy: "10.0.0.0/8", addr: "10.1.2.3:22", want: false},
+		{noProxy: "10.0.0.0/8", addr: "192.0.2.1:22", want: true},
+		{noProxy: "*", addr: "example.com:22", want: false},
+	}
+
+	for _, proxy := range []string{"socks5://proxy:1080", "ssh://jump@bastion:22"} {
+		proxyURL, err := url.Parse(proxy)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		for _, test := range tests {
+			t.Run(proxy+" "+test.noProxy+" "+test.addr, func(t *testing.T) {
+				provider := &SSHProvider{Config: &options.Options{
+					ProxyURL: proxyURL,
+					NoProxy:  test.noProxy,
+				}}
+
+				got := useProxy(provider, test.addr)
+				if got != test.want {
+					t.Errorf("useProxy = %t, want %t", got, test.want)
+				}
+			})
+		}
+	}
+}
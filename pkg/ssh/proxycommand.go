@@ -0,0 +1,177 @@
+package ssh
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"os/exec"
+	"runtime"
+	"strings"
+	"time"
+)
+
+// proxyCommandConn is a net.Conn over the stdin and stdout of a ProxyCommand.
+type proxyCommandConn struct {
+	command string
+	cmd     *exec.Cmd
+	stdin   io.WriteCloser
+	stdout  io.ReadCloser
+
+	exited  chan struct{}
+	exitErr error
+}
+
+var _ net.Conn = &proxyCommandConn{}
+
+// hostDialer returns the dialer of a host that is not behind a jump host. It
+// runs the ProxyCommand of the host if it has one, otherwise it goes through
+// PROXY_URL.
+func hostDialer(provider *SSHProvider, config *hostConfig) dialFunc {
+	if config.ProxyCommand == "" || config.ProxyCommand == "none" {
+		return proxyDialer(provider, config.ConnectTimeout)
+	}
+
+	return func(_ string, _ string) (net.Conn, error) {
+		return dialProxyCommand(config)
+	}
+}
+
+func dialProxyCommand(config *hostConfig) (net.Conn, error) {
+	command := expandProxyCommand(config)
+	if runtime.GOOS != "windows" {
+		command = "exec " + command
+	}
+
+	// use our own pipes, the ones of exec.Cmd are closed by Wait before we
+	// may have read everything
+	stdinReader, stdinWriter, err := os.Pipe()
+	if err != nil {
+		return nil, fmt.Errorf("proxy command: %w", err)
+	}
+	stdoutReader, stdoutWriter, err := os.Pipe()
+	if err != nil {
+		_ = stdinReader.Close()
+		_ = stdinWriter.Close()
+		return nil, fmt.Errorf("proxy command: %w", err)
+	}
+
+	cmd := shellCommand(command)
+	cmd.Stdin = stdinReader
+	cmd.Stdout = stdoutWriter
+	cmd.Stderr = os.Stderr
+	err = cmd.Start()
+	_ = stdinReader.Close()
+	_ = stdoutWriter.Close()
+	if err != nil {
+		_ = stdinWriter.Close()
+		_ = stdoutReader.Close()
+		return nil, fmt.Errorf("start proxy command %q: %w", config.ProxyCommand, err)
+	}
+
+	conn := &proxyCommandConn{
+		command: config.ProxyCommand,
+		cmd:     cmd,
+		stdin:   stdinWriter,
+		stdout:  stdoutReader,
+		exited:  make(chan struct{}),
+	}
+	go func() {
+		conn.exitErr = cmd.Wait()
+		close(conn.exited)
+	}()
+
+	return conn, nil
+}
+
+// expandProxyCommand expands the %h, %p, %r and %n tokens of the ProxyCommand.
+func expandProxyCommand(config *hostConfig) string {
+	replacer := strings.NewReplacer(
+		"%%", "%",
+		"%h", config.Hostname,
+		"%p", config.Port,
+		"%r", config.User,
+		"%n", config.Host,
+	)
+
+	return replacer.Replace(config.ProxyCommand)
+}
+
+func (c *proxyCommandConn) Read(b []byte) (int, error) {
+	n, err := c.stdout.Read(b)
+	if err != nil {
+		err = c.commandError(err)
+	}
+
+	return n, err
+}
+
+func (c *proxyCommandConn) Write(b []byte) (int, error) {
+	n, err := c.stdin.Write(b)
+	if err != nil {
+		err = c.commandError(err)
+	}
+
+	return n, err
+}
+
+// Close closes the stdin of the proxy command and gives it a moment to exit
+// on its own before it is killed.
+func (c *proxyCommandConn) Close() error {
+	_ = c.stdin.Close()
+
+	select {
+	case <-c.exited:
+	case <-time.After(time.Second * 2):
+		_ = c.cmd.Process.Kill()
+		<-c.exited
+	}
+
+	return c.stdout.Close()
+}
+
+// commandError reports the exit status of the proxy command instead of the
+// I/O error it caused, if the command failed.
+func (c *proxyCommandConn) commandError(err error) error {
+	select {
+	case <-c.exited:
+	case <-time.After(time.Millisecond * 100):
+		return err
+	}
+
+	if c.exitErr != nil {
+		return fmt.Errorf("proxy command %q failed: %w", c.command, c.exitErr)
+	}
+
+	return err
+}
+
+func (c *proxyCommandConn) LocalAddr() net.Addr {
+	return proxyCommandAddr{}
+}
+
+func (c *proxyCommandConn) RemoteAddr() net.Addr {
+	return proxyCommandAddr{}
+}
+
+func (c *proxyCommandConn) SetDeadline(_ time.Time) error {
+	return nil
+}
+
+func (c *proxyCommandConn) SetReadDeadline(_ time.Time) error {
+	return nil
+}
+
+func (c *proxyCommandConn) SetWriteDeadline(_ time.Time) error {
+	return nil
+}
+
+type proxyCommandAddr struct{}
+
+func (proxyCommandAddr) Network() string {
+	return "proxy-command"
+}
+
+func (proxyCommandAddr) String() string {
+	return "proxy-command"
+}
@@ -0,0 +1,104 @@
+package ssh
+
+import (
+	"context"
+	"errors"
+	"os"
+	"os/exec"
+	"syscall"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// signalGracePeriod is how long the remote command gets to exit after it was
+// signaled before the session is closed.
+const signalGracePeriod = time.Second * 5
+
+// SignalError is the cause of a context that was canceled because the
+// provider received Signal.
+type SignalError struct {
+	Signal os.Signal
+}
+
+func (e *SignalError) Error() string {
+	return "received signal " + e.Signal.String()
+}
+
+// signalCause returns the signal that canceled ctx, SIGTERM if it was
+// canceled for another reason.
+func signalCause(ctx context.Context) os.Signal {
+	signalErr := &SignalError{}
+	if errors.As(context.Cause(ctx), &signalErr) {
+		return signalErr.Signal
+	}
+
+	return syscall.SIGTERM
+}
+
+// remoteSignal returns the name of sig in the ssh protocol.
+func remoteSignal(sig os.Signal) ssh.Signal {
+	switch sig {
+	case os.Interrupt:
+		return ssh.SIGINT
+	case syscall.SIGHUP:
+		return ssh.SIGHUP
+	default:
+		return ssh.SIGTERM
+	}
+}
+
+// forwardSignal sends the signal that canceled ctx to the remote command of
+// sess. The session is closed if the command doesn't exit within
+// signalGracePeriod. The returned function stops forwarding, it has to be
+// called once the command exited.
+func forwardSignal(ctx context.Context, provider *SSHProvider, sess *ssh.Session) func() {
+	done := make(chan struct{})
+	go func() {
+		select {
+		case <-done:
+			return
+		case <-ctx.Done():
+		}
+
+		sig := remoteSignal(signalCause(ctx))
+		provider.Log.Debugf("forward SIG%s to the remote command", sig)
+		err := sess.Signal(sig)
+		if err != nil {
+			provider.Log.Debugf("forward SIG%s: %v", sig, err)
+		}
+
+		select {
+		case <-done:
+		case <-time.After(signalGracePeriod):
+			_ = sess.Close()
+		}
+	}()
+
+	return func() { close(done) }
+}
+
+// terminateOnCancel makes cmd pass the signal that canceled its context on to
+// the process, so ssh can close the connection in an orderly way. It is
+// killed if it doesn't exit within signalGracePeriod.
+func terminateOnCancel(ctx context.Context, cmd *exec.Cmd) {
+	cmd.Cancel = func() error {
+		err := cmd.Process.Signal(signalCause(ctx))
+		if err != nil && !errors.Is(err, os.ErrProcessDone) {
+			// windows only supports killing the process
+			return cmd.Process.Kill()
+		}
+
+		return err
+	}
+	cmd.WaitDelay = signalGracePeriod
+}
+
+// canceled returns the cause of ctx if it was canceled, otherwise err.
+func canceled(ctx context.Context, err error) error {
+	if ctx.Err() != nil {
+		return context.Cause(ctx)
+	}
+
+	return err
+}
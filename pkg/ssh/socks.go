@@ -0,0 +1,110 @@
+package ssh
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+)
+
+// The SOCKS5 values of RFC 1928 the dynamic forward uses.
+const (
+	socksVersion       = 5
+	socksNoAuth        = 0
+	socksNoMethods     = 0xff
+	socksConnect       = 1
+	socksIPv4          = 1
+	socksDomain        = 3
+	socksIPv6          = 4
+	socksSucceeded     = 0
+	socksUnsupported   = 7
+	socksConnRefused   = 5
+	socksReplyTemplate = "\x05\x00\x00\x01\x00\x00\x00\x00\x00\x00"
+)
+
+// socksHandshake answers the SOCKS5 greeting and request on conn and returns
+// the address the client wants to connect to. Like ssh -D it only supports
+// CONNECT without authentication. The reply is sent with socksReply once the
+// connection is established.
+func socksHandshake(conn net.Conn) (string, error) {
+	header := make([]byte, 2)
+	_, err := io.ReadFull(conn, header)
+	if err != nil {
+		return "", err
+	}
+	if header[0] != socksVersion {
+		return "", fmt.Errorf("unsupported SOCKS version %d", header[0])
+	}
+
+	methods := make([]byte, header[1])
+	_, err = io.ReadFull(conn, methods)
+	if err != nil {
+		return "", err
+	}
+	method := byte(socksNoMethods)
+	for _, m := range methods {
+		if m == socksNoAuth {
+			method = socksNoAuth
+		}
+	}
+	_, err = conn.Write([]byte{socksVersion, method})
+	if err != nil {
+		return "", err
+	}
+	if method == socksNoMethods {
+		return "", errors.New("SOCKS client requires authentication")
+	}
+
+	request := make([]byte, 4)
+	_, err = io.ReadFull(conn, request)
+	if err != nil {
+		return "", err
+	}
+	if request[1] != socksConnect {
+		socksReply(conn, socksUnsupported)
+		return "", fmt.Errorf("unsupported SOCKS command %d", request[1])
+	}
+
+	var host string
+	switch request[3] {
+	case socksIPv4, socksIPv6:
+		ip := make(net.IP, net.IPv4len)
+		if request[3] == socksIPv6 {
+			ip = make(net.IP, net.IPv6len)
+		}
+		_, err = io.ReadFull(conn, ip)
+		host = ip.String()
+	case socksDomain:
+		length := make([]byte, 1)
+		_, err = io.ReadFull(conn, length)
+		if err == nil {
+			name := make([]byte, length[0])
+			_, err = io.ReadFull(conn, name)
+			host = string(name)
+		}
+	default:
+		socksReply(conn, socksUnsupported)
+		return "", fmt.Errorf("unsupported SOCKS address type %d", request[3])
+	}
+	if err != nil {
+		return "", err
+	}
+
+	port := make([]byte, 2)
+	_, err = io.ReadFull(conn, port)
+	if err != nil {
+		return "", err
+	}
+
+	return net.JoinHostPort(host, strconv.Itoa(int(binary.BigEndian.Uint16(port)))), nil
+}
+
+// socksReply sends the reply to the request of socksHandshake. The bound
+// address is left empty, clients don't use it for CONNECT.
+func socksReply(conn net.Conn, status byte) {
+	reply := []byte(socksReplyTemplate)
+	reply[1] = status
+	_, _ = conn.Write(reply)
+}
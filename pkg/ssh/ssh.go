@@ -5,24 +5,42 @@ import (
 	"context"
 	"fmt"
 	"io"
-	"net"
 	"os"
-	"os/exec"
 	"path"
 	"path/filepath"
 	"strings"
-	"time"
+	"sync"
 
 	"github.com/kballard/go-shellquote"
 	"github.com/skevetter/devpod-provider-ssh/pkg/options"
-	"github.com/skevetter/devpod/pkg/ssh"
 	"github.com/skevetter/log"
+	"golang.org/x/crypto/ssh"
 )
 
 type SSHProvider struct {
 	Config           *options.Options
 	Log              log.Logger
 	WorkingDirectory string
+
+	// client is the connection of the builtin client, it is shared by all
+	// commands of the process
+	client *ssh.Client
+	// lostErr is set when the keepalive closed client
+	lostErr error
+	lostMu  sync.Mutex
+
+	// agentForwarding is set if the agent is forwarded on client
+	agentForwarding bool
+
+	controlStarted  bool
+	controlDisabled bool
+
+	// localTransport caches the result of TRANSPORT=auto
+	transportChecked bool
+	localTransport   bool
+
+	// hostConfigs caches the resolved ssh config by host, user and port
+	hostConfigs map[string]*hostConfig
 }
 
 func NewProvider(logs log.Logger) (*SSHProvider, error) {
@@ -40,7 +58,16 @@ func NewProvider(logs log.Logger) (*SSHProvider, error) {
 	return provider, nil
 }
 
-func returnSSHError(provider *SSHProvider, command string) error {
+// returnSSHError returns the error for a failed check command, or the cause of
+// ctx if it was canceled.
+func returnSSHError(ctx context.Context, provider *SSHProvider, command string) error {
+	if ctx.Err() != nil {
+		return context.Cause(ctx)
+	}
+	if useLocalTransport(provider) {
+		return fmt.Errorf("%s failed on the local host", command)
+	}
+
 	sshError := "Please make sure you have configured the correct SSH host\nand the following command can be executed on your system:\n"
 
 	sshcmd, err := getSSHCommand(provider)
@@ -52,10 +79,48 @@ func returnSSHError(provider *SSHProvider, command string) error {
 }
 
 func getSSHCommand(provider *SSHProvider) ([]string, error) {
-	result := []string{"-oStrictHostKeyChecking=no", "-oBatchMode=yes"}
+	result, err := getCommonFlags(provider, "-p")
+	if err != nil {
+		return nil, err
+	}
+
+	result = append(result, getSendEnvFlags(provider)...)
+	if provider.Config.User != "" {
+		result = append(result, "-l", provider.Config.User)
+	}
+	result = append(result, provider.Config.Host)
+	return result, nil
+}
+
+// getCommonFlags returns the flags shared by ssh and scp, portFlag differs
+// between the two.
+func getCommonFlags(provider *SSHProvider, portFlag string) ([]string, error) {
+	result, err := getHostKeyFlags(provider)
+	if err != nil {
+		return nil, err
+	}
+	result = append(result, getAlgorithmFlags(provider)...)
+	if !provider.Config.AllowInteractiveAuth {
+		result = append(result, "-oBatchMode=yes")
+	}
+	if provider.Config.ForwardAgent {
+		result = append(result, "-oForwardAgent=yes")
+	}
+	result = append(result, getKeepAliveFlags(provider)...)
+	result = append(result, getConnectTimeoutFlags(provider)...)
+
+	proxyFlags, err := getProxyFlags(provider)
+	if err != nil {
+		return nil, err
+	}
+	result = append(result, proxyFlags...)
 
 	if provider.Config.Port != "22" {
-		result = append(result, []string{"-p", provider.Config.Port}...)
+		result = append(result, []string{portFlag, provider.Config.Port}...)
+	}
+
+	if provider.Config.JumpHosts != "" {
+		result = append(result, []string{"-J", provider.Config.JumpHosts}...)
 	}
 
 	if provider.Config.ExtraFlags != "" {
@@ -67,80 +132,75 @@ func getSSHCommand(provider *SSHProvider) ([]string, error) {
 		result = append(result, flags...)
 	}
 
-	result = append(result, provider.Config.Host)
+	result = append(result, getControlFlags(provider)...)
 	return result, nil
 }
 
-func execSSHCommand(provider *SSHProvider, command string, output io.Writer) error {
-	if provider.Config.UseBuiltinSSH {
-		// get ssh config for host
-		ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
-		defer cancel()
-		sshConfig, err := exec.CommandContext(ctx, "ssh", "-G", provider.Config.Host).Output()
-		if err != nil {
-			return fmt.Errorf("read ssh config for host %s: %w", provider.Config.Host, err)
-		}
-		hostname, user, port, identityfile := parseConfig(string(sshConfig))
-		if hostname == "" || user == "" || port == "" {
-			return fmt.Errorf(
-				"resolve ssh config. Hostname='%s', User='%s', Port='%s'",
-				hostname,
-				user,
-				port,
-			)
-		}
+func execSSHCommand(
+	ctx context.Context,
+	provider *SSHProvider,
+	command string,
+	output io.Writer,
+) error {
+	return runSSHCommand(ctx, provider, command, commandIO{stdin: os.Stdin, output: output})
+}
 
-		// expand identityfile path
-		if strings.HasPrefix(identityfile, "~") {
-			identityfile = strings.Replace(identityfile, "~", "$userprofile", 1)
-			identityfile = os.ExpandEnv(identityfile)
-		}
-		abs, err := filepath.Abs(identityfile)
-		if err != nil {
-			return fmt.Errorf("absolute filepath: %w", err)
-		}
-		key, err := os.ReadFile(abs)
-		if err != nil {
-			return fmt.Errorf("read identifiyfile: %w", err)
-		}
+// commandIO is where the input and output of a remote command go. Only
+// interactive commands get a TTY according to REQUEST_TTY, the output of the
+// others is parsed.
+type commandIO struct {
+	stdin       io.Reader
+	output      io.Writer
+	interactive bool
+}
 
-		if provider.Config.Port != "" {
-			port = provider.Config.Port
-		}
-		// create ssh session
-		addr := net.JoinHostPort(hostname, port)
-		client, err := ssh.NewSSHClient(user, addr, key)
-		if err != nil {
-			return fmt.Errorf("create ssh client: %w", err)
-		}
-		sess, err := client.NewSession()
-		if err != nil {
-			return fmt.Errorf("create ssh session: %w", err)
-		}
-		sess.Stdin = os.Stdin
-		sess.Stdout = output
+// runSSHCommand runs command on the host. If ctx is canceled the remote
+// command is signaled and its cause is returned.
+func runSSHCommand(
+	ctx context.Context,
+	provider *SSHProvider,
+	command string,
+	cmdIO commandIO,
+) error {
+	if ctx.Err() != nil {
+		return context.Cause(ctx)
+	}
+
+	if useLocalTransport(provider) {
+		return runLocalCommand(ctx, command, cmdIO)
+	}
 
-		return sess.Run(command)
+	if provider.Config.UseBuiltinSSH {
+		return runBuiltinCommand(ctx, provider, command, cmdIO)
 	}
 
+	startControlMaster(ctx, provider)
 	commandToRun, err := getSSHCommand(provider)
 	if err != nil {
 		return err
 	}
 
+	if cmdIO.interactive {
+		commandToRun = append(getTTYFlags(provider), commandToRun...)
+	}
 	commandToRun = append(commandToRun, command)
 
 	var stderrBuf bytes.Buffer
+	err = withRetries(ctx, provider, func() (bool, error) {
+		stderrBuf.Reset()
 
-	cmd := exec.Command("ssh", commandToRun...)
-	cmd.Stdin = os.Stdin
-	cmd.Stdout = output
-	cmd.Stderr = io.Writer(&stderrBuf)
+		cmd := sshCommand(ctx, provider, "ssh", commandToRun...)
+		cmd.Stdin = cmdIO.stdin
+		cmd.Stdout = cmdIO.output
+		cmd.Stderr = io.Writer(&stderrBuf)
 
-	err = cmd.Run()
+		return checkSSHConnectError(cmd.Run(), stderrBuf.String())
+	})
 	if err != nil {
-		provider.Log.Error(stderrBuf.String())
-		return err
+		if stderrBuf.Len() > 0 {
+			provider.Log.Error(stderrBuf.String())
+		}
+		return canceled(ctx, err)
 	}
 
 	// A non-POSIX shell has been detected: falling back to copy and execute scripts
@@ -149,19 +209,57 @@ func execSSHCommand(provider *SSHProvider, command string, output io.Writer) err
 			"A non-POSIX shell has been detected: falling back to copy and execute scripts",
 		)
 
-		return copyAndExecSSHCommand(provider, command, output)
+		return copyAndExecSSHCommand(ctx, provider, command, cmdIO)
 	}
 
 	return err
 }
 
-func copyAndExecSSHCommand(provider *SSHProvider, command string, output io.Writer) error {
+// runBuiltinCommand runs command in a session of the builtin client.
+func runBuiltinCommand(
+	ctx context.Context,
+	provider *SSHProvider,
+	command string,
+	cmdIO commandIO,
+) error {
+	sess, err := newSession(ctx, provider)
+	if err != nil {
+		return canceled(ctx, err)
+	}
+	defer func() { _ = sess.Close() }()
+
+	command = sendEnv(provider, sess, command)
+	stopForwarding := forwardSignal(ctx, provider, sess)
+	if cmdIO.interactive && wantTTY(provider) {
+		err = runWithTTY(sess, command, cmdIO.output)
+	} else {
+		sess.Stdin = cmdIO.stdin
+		sess.Stdout = cmdIO.output
+		sess.Stderr = os.Stderr
+		err = sess.Run(command)
+	}
+	stopForwarding()
+	if lost := provider.connectionLost(); err != nil && lost != nil {
+		return lost
+	}
+	if err != nil {
+		return canceled(ctx, err)
+	}
+	return nil
+}
+
+func copyAndExecSSHCommand(
+	ctx context.Context,
+	provider *SSHProvider,
+	command string,
+	cmdIO commandIO,
+) error {
 	commandToRun, err := getSSHCommand(provider)
 	if err != nil {
 		return err
 	}
 
-	script, err := copyCommandToRemote(provider, command)
+	script, err := copyCommandToRemote(ctx, provider, command)
 	if err != nil {
 		return err
 	}
@@ -170,15 +268,19 @@ func copyAndExecSSHCommand(provider *SSHProvider, command string, output io.Writ
 		"/bin/sh", script, ";", "rm", "-f", script,
 	}...)
 
-	cmd := exec.Command("ssh", commandToRun...)
-	cmd.Stdin = os.Stdin
-	cmd.Stdout = output
+	cmd := sshCommand(ctx, provider, "ssh", commandToRun...)
+	cmd.Stdin = cmdIO.stdin
+	cmd.Stdout = cmdIO.output
 	cmd.Stderr = os.Stderr
 
-	return cmd.Run()
+	return canceled(ctx, cmd.Run())
 }
 
-func copyCommandToRemote(provider *SSHProvider, command string) (string, error) {
+func copyCommandToRemote(
+	ctx context.Context,
+	provider *SSHProvider,
+	command string,
+) (string, error) {
 	script, err := os.CreateTemp("", "devpod-command-*")
 	if err != nil {
 		return "", err
@@ -198,38 +300,42 @@ func copyCommandToRemote(provider *SSHProvider, command string) (string, error)
 		return "", err
 	}
 
-	return script.Name(), exec.Command("scp", commandToRun...).Run()
+	return script.Name(), sshCommand(ctx, provider, "scp", commandToRun...).Run()
 }
 
 func getSCPCommand(provider *SSHProvider, sourcefile string) ([]string, error) {
-	result := []string{"-oStrictHostKeyChecking=no", "-oBatchMode=yes"}
-
-	if provider.Config.Port != "22" {
-		result = append(result, []string{"-p", provider.Config.Port}...)
-	}
-
-	if provider.Config.ExtraFlags != "" {
-		flags, err := shellquote.Split(provider.Config.ExtraFlags)
-		if err != nil {
-			return nil, fmt.Errorf("error managing EXTRA_ARGS, %v", err)
-		}
-
-		result = append(result, flags...)
+	result, err := getCommonFlags(provider, "-P")
+	if err != nil {
+		return nil, err
 	}
 
 	destfile := "/tmp/" + filepath.Base(sourcefile)
 
 	result = append(result, sourcefile)
-	result = append(result, provider.Config.Host+":"+destfile)
+	result = append(result, scpDestination(provider, destfile))
 	return result, nil
 }
 
-func Init(provider *SSHProvider) error {
+// scpDestination returns the scp destination of path on the host, IPv6
+// addresses need brackets to be told apart from the path.
+func scpDestination(provider *SSHProvider, path string) string {
+	host := provider.Config.Host
+	if strings.Contains(host, ":") {
+		host = "[" + host + "]"
+	}
+	if provider.Config.User != "" {
+		host = provider.Config.User + "@" + host
+	}
+
+	return host + ":" + path
+}
+
+func Init(ctx context.Context, provider *SSHProvider) error {
 	out := new(bytes.Buffer)
 	// check that we can do outputs
-	err := execSSHCommand(provider, "echo Devpod Test", out)
+	err := execSSHCommand(ctx, provider, "echo Devpod Test", out)
 	if err != nil {
-		return returnSSHError(provider, "echo Devpod Test")
+		return returnSSHError(ctx, provider, "echo Devpod Test")
 	}
 	if out.String() != "Devpod Test\n" {
 		return fmt.Errorf("error: ssh output mismatch")
@@ -237,9 +343,9 @@ func Init(provider *SSHProvider) error {
 
 	// We only support running on Linux ssh servers
 	out = new(bytes.Buffer)
-	err = execSSHCommand(provider, "uname", out)
+	err = execSSHCommand(ctx, provider, "uname", out)
 	if err != nil {
-		return returnSSHError(provider, "uname")
+		return returnSSHError(ctx, provider, "uname")
 	}
 	if out.String() != "Linux\n" {
 		fmt.Println(out.String())
@@ -248,9 +354,9 @@ func Init(provider *SSHProvider) error {
 
 	// If we're root, we won't have problems
 	out = new(bytes.Buffer)
-	err = execSSHCommand(provider, "id -ru", out)
+	err = execSSHCommand(ctx, provider, "id -ru", out)
 	if err != nil {
-		return returnSSHError(provider, "id -ru")
+		return returnSSHError(ctx, provider, "id -ru")
 	}
 	if out.String() == "0\n" {
 		return nil
@@ -259,11 +365,14 @@ func Init(provider *SSHProvider) error {
 	// check that we have access to AGENT_PATH
 	out = new(bytes.Buffer)
 	agentDir := path.Dir(provider.Config.AgentPath)
-	err1 := execSSHCommand(provider, "mkdir -p "+agentDir, out)
-	err2 := execSSHCommand(provider, "test -w "+agentDir, out)
+	err1 := execSSHCommand(ctx, provider, "mkdir -p "+agentDir, out)
+	err2 := execSSHCommand(ctx, provider, "test -w "+agentDir, out)
 	if err1 != nil || err2 != nil {
-		err = execSSHCommand(provider, "sudo -nl", out)
+		err = execSSHCommand(ctx, provider, "sudo -nl", out)
 		if err != nil {
+			if ctx.Err() != nil {
+				return context.Cause(ctx)
+			}
 			return fmt.Errorf(
 				"%s is not writable, passwordless sudo or root user required",
 				agentDir,
@@ -272,10 +381,13 @@ func Init(provider *SSHProvider) error {
 	}
 
 	// check that we have access to DOCKER_PATH
-	err = execSSHCommand(provider, provider.Config.DockerPath+" ps", out)
+	err = execSSHCommand(ctx, provider, provider.Config.DockerPath+" ps", out)
 	if err != nil {
-		err = execSSHCommand(provider, "sudo -nl", out)
+		err = execSSHCommand(ctx, provider, "sudo -nl", out)
 		if err != nil {
+			if ctx.Err() != nil {
+				return context.Cause(ctx)
+			}
 			return fmt.Errorf(
 				"%s not found, passwordless sudo or root user required. If using another user please add to the docker group",
 				provider.Config.DockerPath,
@@ -286,34 +398,26 @@ func Init(provider *SSHProvider) error {
 	return nil
 }
 
-func Command(provider *SSHProvider, command string) error {
-	return execSSHCommand(provider, command, os.Stdout)
-}
-
-func parseConfig(config string) (hostname string, user string, port string, identityfile string) {
-	for _, line := range strings.Split(config, "\n") {
-		fields := strings.Fields(line)
-		if len(fields) != 2 {
-			continue
-		}
-		if fields[0] == "hostname" {
-			hostname = fields[1]
-			continue
-		}
-		if fields[0] == "user" {
-			user = fields[1]
-			continue
-		}
-		if fields[0] == "port" {
-			port = fields[1]
-			continue
-		}
-		// just take the first one
-		if fields[0] == "identityfile" && identityfile == "" {
-			identityfile = fields[1]
-			continue
-		}
+// Close closes the connection of the builtin client.
+func (provider *SSHProvider) Close() error {
+	if provider.client == nil {
+		return nil
 	}
 
-	return hostname, user, port, identityfile
+	err := provider.client.Close()
+	provider.lostMu.Lock()
+	provider.client = nil
+	provider.lostErr = nil
+	provider.lostMu.Unlock()
+	return err
+}
+
+func Command(ctx context.Context, provider *SSHProvider, command string) error {
+	recordWorkspace(ctx, provider)
+	return runSSHCommand(
+		ctx,
+		provider,
+		command,
+		commandIO{stdin: os.Stdin, output: os.Stdout, interactive: true},
+	)
 }
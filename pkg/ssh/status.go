@@ -0,0 +1,90 @@
+package ssh
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/kballard/go-shellquote"
+)
+
+// The states of DevPod's machine status protocol.
+const (
+	StatusRunning  = "Running"
+	StatusBusy     = "Busy"
+	StatusStopped  = "Stopped"
+	StatusNotFound = "NotFound"
+)
+
+// Status returns the state of the host. It is NotFound if the host name
+// doesn't resolve and Stopped if the host can't be reached or its docker
+// daemon isn't healthy. While LOCK_FILE exists on the host it is Busy.
+// Unreachable hosts are not retried, the status is reported right away.
+func Status(ctx context.Context, provider *SSHProvider) (string, error) {
+	provider.Config.ConnectRetries = 0
+
+	out := new(bytes.Buffer)
+	err := execSSHCommand(ctx, provider, statusScript(provider), out)
+	if err != nil {
+		switch {
+		case ctx.Err() != nil:
+			return "", context.Cause(ctx)
+		case isHostNotFound(err):
+			return StatusNotFound, nil
+		case isUnreachable(err):
+			return StatusStopped, nil
+		}
+
+		return "", fmt.Errorf("get status of %s: %w", provider.Config.Host, err)
+	}
+
+	status := strings.TrimSpace(out.String())
+	switch status {
+	case StatusRunning, StatusBusy, StatusStopped:
+		return status, nil
+	}
+
+	return "", fmt.Errorf("get status of %s: unexpected output %q", provider.Config.Host, status)
+}
+
+// statusScript prints Busy if the lock file exists, Running if the docker
+// daemon responds and Stopped otherwise.
+func statusScript(provider *SSHProvider) string {
+	docker := provider.Config.DockerPath + " info >/dev/null 2>&1"
+	script := fmt.Sprintf(
+		"if %s || sudo -n %s; then echo %s; else echo %s; fi",
+		docker,
+		docker,
+		StatusRunning,
+		StatusStopped,
+	)
+	if provider.Config.LockFile == "" {
+		return script
+	}
+
+	return fmt.Sprintf(
+		"if [ -e %s ]; then echo %s; else %s; fi",
+		shellquote.Join(provider.Config.LockFile),
+		StatusBusy,
+		script,
+	)
+}
+
+func isHostNotFound(err error) bool {
+	dnsErr := &net.DNSError{}
+	if errors.As(err, &dnsErr) {
+		return dnsErr.IsNotFound
+	}
+
+	connectErr := &sshConnectError{}
+	return errors.As(err, &connectErr) &&
+		strings.HasPrefix(connectErr.message, "ssh: Could not resolve hostname ")
+}
+
+func isUnreachable(err error) bool {
+	connectErr := &sshConnectError{}
+	return isConnectError(err) || errors.As(err, &connectErr)
+}
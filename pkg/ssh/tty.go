@@ -0,0 +1,108 @@
+package ssh
+
+import (
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/skevetter/devpod-provider-ssh/pkg/options"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/term"
+)
+
+// wantTTY returns whether a PTY should be requested for an interactive
+// command. REQUEST_TTY=yes always requests one and no never does, auto
+// requests one if stdin is a terminal. Other commands never get a PTY.
+func wantTTY(provider *SSHProvider) bool {
+	switch provider.Config.RequestTTY {
+	case options.RequestTTYYes:
+		return true
+	case options.RequestTTYNo:
+		return false
+	}
+
+	return term.IsTerminal(int(os.Stdin.Fd()))
+}
+
+// getTTYFlags returns the ssh flags for REQUEST_TTY of an interactive
+// command, following the same rules as wantTTY.
+func getTTYFlags(provider *SSHProvider) []string {
+	switch provider.Config.RequestTTY {
+	case options.RequestTTYYes:
+		// a single -t is ignored if stdin isn't a terminal
+		return []string{"-tt"}
+	case options.RequestTTYNo:
+		return []string{"-T"}
+	}
+
+	if wantTTY(provider) {
+		return []string{"-t"}
+	}
+
+	return nil
+}
+
+// runWithTTY runs command in sess with a PTY the size of the local terminal.
+// The local terminal is in raw mode while the command runs and its size
+// changes are forwarded to the session.
+func runWithTTY(sess *ssh.Session, command string, output io.Writer) error {
+	stdin := int(os.Stdin.Fd())
+	if term.IsTerminal(stdin) {
+		state, err := term.MakeRaw(stdin)
+		if err != nil {
+			return fmt.Errorf("set terminal to raw mode: %w", err)
+		}
+		defer func() { _ = term.Restore(stdin, state) }()
+	}
+
+	width, height := terminalSize()
+	termName := os.Getenv("TERM")
+	if termName == "" {
+		termName = "xterm-256color"
+	}
+
+	err := sess.RequestPty(termName, height, width, ssh.TerminalModes{
+		ssh.ECHO:          1,
+		ssh.TTY_OP_ISPEED: 14400,
+		ssh.TTY_OP_OSPEED: 14400,
+	})
+	if err != nil {
+		return fmt.Errorf("request pty: %w", err)
+	}
+
+	// Session.Wait waits for its stdin to be fully copied, which would keep
+	// the command open until the user presses a key after the remote side
+	// exited
+	remoteStdin, err := sess.StdinPipe()
+	if err != nil {
+		return fmt.Errorf("create stdin pipe: %w", err)
+	}
+	go func() {
+		_, _ = io.Copy(remoteStdin, os.Stdin)
+		_ = remoteStdin.Close()
+	}()
+	sess.Stdout = output
+	sess.Stderr = os.Stderr
+
+	done := make(chan struct{})
+	defer close(done)
+	watchTerminalSize(done, func() {
+		width, height := terminalSize()
+		_ = sess.WindowChange(height, width)
+	})
+
+	return sess.Run(command)
+}
+
+// terminalSize returns the size of the local terminal, or 80x24 if there is
+// none.
+func terminalSize() (int, int) {
+	for _, f := range []*os.File{os.Stdout, os.Stdin, os.Stderr} {
+		width, height, err := term.GetSize(int(f.Fd()))
+		if err == nil && width > 0 && height > 0 {
+			return width, height
+		}
+	}
+
+	return 80, 24
+}
@@ -0,0 +1,28 @@
+//go:build !windows
+
+package ssh
+
+import (
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+// watchTerminalSize calls resize whenever the local terminal is resized,
+// until done is closed.
+func watchTerminalSize(done <-chan struct{}, resize func()) {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGWINCH)
+
+	go func() {
+		defer signal.Stop(signals)
+		for {
+			select {
+			case <-done:
+				return
+			case <-signals:
+				resize()
+			}
+		}
+	}()
+}
@@ -0,0 +1,30 @@
+//go:build windows
+
+package ssh
+
+import (
+	"time"
+)
+
+// watchTerminalSize calls resize whenever the local terminal is resized,
+// until done is closed. Windows has no resize signal, so the size is polled.
+func watchTerminalSize(done <-chan struct{}, resize func()) {
+	go func() {
+		ticker := time.NewTicker(time.Millisecond * 500)
+		defer ticker.Stop()
+
+		width, height := terminalSize()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				newWidth, newHeight := terminalSize()
+				if newWidth != width || newHeight != height {
+					width, height = newWidth, newHeight
+					resize()
+				}
+			}
+		}
+	}()
+}
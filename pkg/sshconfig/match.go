@@ -0,0 +1,222 @@
+package sshconfig
+
+import (
+	"crypto/sha1" // #nosec G505 -- %C is defined as a sha1 hash by ssh
+	"encoding/hex"
+	"fmt"
+	"os"
+	"os/exec"
+	"regexp"
+	"runtime"
+	"strconv"
+	"strings"
+)
+
+var envPattern = regexp.MustCompile(`\$\{([A-Za-z_][A-Za-z0-9_]*)\}`)
+
+// unsupportedCriteriaError is returned for Match criteria of newer ssh
+// versions. The block is skipped instead of failing the whole config.
+type unsupportedCriteriaError struct {
+	criterion string
+}
+
+func (e *unsupportedCriteriaError) Error() string {
+	return "unsupported Match criteria " + e.criterion
+}
+
+// match evaluates the criteria of a Match line, all criteria have to match.
+func (r *resolver) match(args []string) (bool, error) {
+	if len(args) == 0 {
+		return false, fmt.Errorf("missing Match criteria")
+	}
+
+	result := true
+	for i := 0; i < len(args); i++ {
+		criterion := strings.ToLower(args[i])
+		negate := strings.HasPrefix(criterion, "!")
+		criterion = strings.TrimPrefix(criterion, "!")
+
+		var matched bool
+		switch criterion {
+		case "all":
+			matched = true
+		case "canonical", "final":
+			r.hasFinal = true
+			matched = r.final
+		case "host", "originalhost", "user", "localuser", "exec", "localnetwork", "tagged":
+			if i+1 >= len(args) {
+				return false, fmt.Errorf("missing argument for Match %s", criterion)
+			}
+			i++
+
+			// like ssh, don't run commands once the result is known
+			if criterion == "exec" && !result {
+				continue
+			}
+			matched = r.matchCriterion(criterion, args[i])
+		default:
+			return false, &unsupportedCriteriaError{criterion: criterion}
+		}
+
+		if matched == negate {
+			result = false
+		}
+	}
+
+	return result, nil
+}
+
+func (r *resolver) matchCriterion(criterion string, arg string) bool {
+	patterns := strings.Split(arg, ",")
+	switch criterion {
+	case "host":
+		return matchPatterns(r.hostname(), patterns, true)
+	case "originalhost":
+		return matchPatterns(r.host, patterns, true)
+	case "user":
+		return matchPatterns(r.user(), patterns, false)
+	case "localuser":
+		return matchPatterns(r.localUser, patterns, false)
+	case "tagged":
+		return matchPatterns(r.config.Get("tag"), patterns, false)
+	case "exec":
+		return r.exec(arg)
+	}
+
+	// localnetwork is not supported
+	return false
+}
+
+// exec runs the command of a Match exec criteria, it matches if the command
+// exits with status 0.
+func (r *resolver) exec(command string) bool {
+	command = r.expandTokens(command)
+
+	var cmd *exec.Cmd
+	if runtime.GOOS == "windows" {
+		// #nosec G204 -- command comes from the user's ssh config
+		cmd = exec.Command("cmd", "/C", command)
+	} else {
+		// #nosec G204 -- command comes from the user's ssh config
+		cmd = exec.Command("/bin/sh", "-c", command)
+	}
+	cmd.Stderr = os.Stderr
+
+	return cmd.Run() == nil
+}
+
+// hostname returns the hostname resolved so far.
+func (r *resolver) hostname() string {
+	hostname := r.config.Get("hostname")
+	if hostname == "" {
+		return r.host
+	}
+
+	return strings.NewReplacer("%%", "%", "%h", r.host).Replace(hostname)
+}
+
+// user returns the remote user resolved so far.
+func (r *resolver) user() string {
+	user := r.config.Get("user")
+	if user == "" {
+		return r.localUser
+	}
+
+	return user
+}
+
+// expandTokens expands the % tokens ssh supports in paths and commands.
+func (r *resolver) expandTokens(value string) string {
+	if !strings.Contains(value, "%") {
+		return value
+	}
+
+	hostname := r.hostname()
+	port := r.config.Get("port")
+	if port == "" {
+		port = "22"
+	}
+	user := r.user()
+
+	localHostname, _ := os.Hostname()
+	shortHostname, _, _ := strings.Cut(localHostname, ".")
+
+	// #nosec G401 -- %C is defined as a sha1 hash by ssh
+	hash := sha1.Sum([]byte(localHostname + hostname + port + user))
+
+	return strings.NewReplacer(
+		"%%", "%",
+		"%C", hex.EncodeToString(hash[:]),
+		"%d", r.home,
+		"%h", hostname,
+		"%i", strconv.Itoa(os.Getuid()),
+		"%L", shortHostname,
+		"%l", localHostname,
+		"%n", r.originalHost,
+		"%p", port,
+		"%r", user,
+		"%u", r.localUser,
+	).Replace(value)
+}
+
+// expandEnv expands ${VAR} environment variables.
+func expandEnv(value string) string {
+	return envPattern.ReplaceAllStringFunc(value, func(match string) string {
+		return os.Getenv(match[2 : len(match)-1])
+	})
+}
+
+// matchPatterns matches name against a ssh pattern list. A matching negated
+// pattern always fails the match.
+func matchPatterns(name string, patterns []string, fold bool) bool {
+	if fold {
+		name = strings.ToLower(name)
+	}
+
+	matched := false
+	for _, pattern := range patterns {
+		negated := strings.HasPrefix(pattern, "!")
+		pattern = strings.TrimPrefix(pattern, "!")
+		if fold {
+			pattern = strings.ToLower(pattern)
+		}
+
+		if !wildcardMatch(pattern, name) {
+			continue
+		}
+		if negated {
+			return false
+		}
+		matched = true
+	}
+
+	return matched
+}
+
+// wildcardMatch matches name against a pattern with * and ? wildcards.
+func wildcardMatch(pattern string, name string) bool {
+	for len(pattern) > 0 {
+		switch pattern[0] {
+		case '*':
+			for i := len(name); i >= 0; i-- {
+				if wildcardMatch(pattern[1:], name[i:]) {
+					return true
+				}
+			}
+			return false
+		case '?':
+			if len(name) == 0 {
+				return false
+			}
+		default:
+			if len(name) == 0 || pattern[0] != name[0] {
+				return false
+			}
+		}
+
+		pattern = pattern[1:]
+		name = name[1:]
+	}
+
+	return len(name) == 0
+}
@@ -0,0 +1,397 @@
+// Package sshconfig resolves the ssh_config(5) directives of a host without
+// the ssh binary, following the same rules as ssh -G.
+package sshconfig
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/user"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"strings"
+)
+
+const maxIncludeDepth = 16
+
+// multiValue are the directives that accumulate their values, all other
+// directives use the first value that was found.
+var multiValue = map[string]bool{
+	"identityfile":    true,
+	"certificatefile": true,
+	"localforward":    true,
+	"remoteforward":   true,
+	"dynamicforward":  true,
+	"sendenv":         true,
+	"setenv":          true,
+}
+
+// rawValue are the directives whose value is the rest of the line.
+var rawValue = map[string]bool{
+	"proxycommand":      true,
+	"localcommand":      true,
+	"remotecommand":     true,
+	"knownhostscommand": true,
+}
+
+// exclusive are pairs of directives that compete, whichever is found first
+// prevents the other from taking effect.
+var exclusive = map[string]string{
+	"proxyjump":    "proxycommand",
+	"proxycommand": "proxyjump",
+}
+
+// pathValue are the directives whose values get tokens, environment
+// variables and ~ expanded.
+var pathValue = []string{
+	"identityfile",
+	"certificatefile",
+	"identityagent",
+	"userknownhostsfile",
+	"controlpath",
+}
+
+var defaultIdentityFiles = []string{
+	"~/.ssh/id_rsa",
+	"~/.ssh/id_ecdsa",
+	"~/.ssh/id_ecdsa_sk",
+	"~/.ssh/id_ed25519",
+	"~/.ssh/id_ed25519_sk",
+	"~/.ssh/id_xmss",
+	"~/.ssh/id_dsa",
+}
+
+// Config holds the resolved directives of a host.
+type Config struct {
+	values   map[string][]string
+	warnings []string
+}
+
+// Get returns the value of directive, multiple arguments are joined with a
+// space. It returns an empty string if the directive isn't set.
+func (c *Config) Get(directive string) string {
+	return strings.Join(c.values[strings.ToLower(directive)], " ")
+}
+
+// GetAll returns all values of directive.
+func (c *Config) GetAll(directive string) []string {
+	return c.values[strings.ToLower(directive)]
+}
+
+// Warnings returns the problems found in the config files that were skipped,
+// like Match criteria of newer ssh versions.
+func (c *Config) Warnings() []string {
+	return c.warnings
+}
+
+func (c *Config) warn(warning string) {
+	if !slices.Contains(c.warnings, warning) {
+		c.warnings = append(c.warnings, warning)
+	}
+}
+
+func (c *Config) set(directive string, values []string) {
+	if other, ok := exclusive[directive]; ok && len(c.values[other]) > 0 {
+		return
+	}
+
+	current, ok := c.values[directive]
+	if !multiValue[directive] {
+		if !ok {
+			c.values[directive] = values
+		}
+		return
+	}
+
+	for _, value := range values {
+		if !slices.Contains(current, value) {
+			current = append(current, value)
+		}
+	}
+	c.values[directive] = current
+}
+
+func (c *Config) setDefault(directive string, values ...string) {
+	if len(c.values[directive]) == 0 {
+		// the values are expanded in place, the defaults must not change
+		c.values[directive] = slices.Clone(values)
+	}
+}
+
+type resolver struct {
+	originalHost string
+	host         string
+	localUser    string
+	home         string
+	systemDir    string
+	config       *Config
+
+	final    bool
+	hasFinal bool
+}
+
+// configFile is the place of a config file in the include tree, relative
+// Include patterns are resolved against includeDir.
+type configFile struct {
+	includeDir string
+	depth      int
+}
+
+func newResolver(host string, home string, systemDir string) *resolver {
+	return &resolver{
+		originalHost: host,
+		host:         strings.ToLower(host),
+		localUser:    localUser(),
+		home:         home,
+		systemDir:    systemDir,
+		config:       &Config{values: map[string][]string{}},
+	}
+}
+
+// Resolve resolves the config of host from the user and the system wide
+// ssh_config. user and port take precedence over the config files if they
+// are set, like the -l and -p flags of ssh.
+func Resolve(host string, user string, port string) (*Config, error) {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return nil, fmt.Errorf("home directory: %w", err)
+	}
+
+	return newResolver(host, home, systemConfigDir()).resolve(user, port)
+}
+
+// resolve resolves the config of the host from the config in the .ssh
+// directory of the home directory and the ssh_config in the system directory.
+func (r *resolver) resolve(user string, port string) (*Config, error) {
+	if user != "" {
+		r.config.set("user", []string{user})
+	}
+	if port != "" {
+		r.config.set("port", []string{port})
+	}
+
+	err := r.readConfigs()
+	if err != nil {
+		return nil, err
+	}
+
+	// Match final blocks are evaluated in a second pass, like ssh does
+	if r.hasFinal {
+		r.final = true
+		err = r.readConfigs()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	r.setDefaults()
+	r.expandPaths()
+
+	return r.config, nil
+}
+
+func (r *resolver) readConfigs() error {
+	userDir := filepath.Join(r.home, ".ssh")
+	err := r.readFile(filepath.Join(userDir, "config"), configFile{includeDir: userDir})
+	if err != nil {
+		return err
+	}
+
+	return r.readFile(filepath.Join(r.systemDir, "ssh_config"), configFile{includeDir: r.systemDir})
+}
+
+func (r *resolver) readFile(path string, file configFile) error {
+	// #nosec G304 -- path is a ssh config file of the user
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	} else if err != nil {
+		return fmt.Errorf("read ssh config %s: %w", path, err)
+	}
+
+	active := true
+	for i, line := range strings.Split(string(data), "\n") {
+		directive, args, err := parseLine(line)
+		if err == nil {
+			active, err = r.apply(directive, args, active, file)
+		}
+		unsupportedErr := &unsupportedCriteriaError{}
+		if errors.As(err, &unsupportedErr) {
+			r.config.warn(fmt.Sprintf("%s line %d: %v, skipping the block", path, i+1, err))
+			active = false
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("%s line %d: %w", path, i+1, err)
+		}
+	}
+
+	return nil
+}
+
+// apply applies a single line of a config file and returns whether the
+// following lines apply to the host.
+func (r *resolver) apply(directive string, args []string, active bool, file configFile) (bool, error) {
+	switch directive {
+	case "":
+		return active, nil
+	case "host":
+		return matchPatterns(r.host, args, true), nil
+	case "match":
+		return r.match(args)
+	case "include":
+		if !active {
+			return active, nil
+		}
+		return active, r.include(args, file)
+	}
+
+	if active && len(args) > 0 {
+		r.config.set(directive, args)
+	}
+	return active, nil
+}
+
+func (r *resolver) include(patterns []string, file configFile) error {
+	if file.depth >= maxIncludeDepth {
+		return errors.New("too many nested includes")
+	}
+
+	for _, pattern := range patterns {
+		pattern = r.expandTilde(pattern)
+		if !filepath.IsAbs(pattern) {
+			pattern = filepath.Join(file.includeDir, pattern)
+		}
+
+		files, err := filepath.Glob(pattern)
+		if err != nil {
+			return fmt.Errorf("include %s: %w", pattern, err)
+		}
+
+		for _, path := range files {
+			err = r.readFile(path, configFile{includeDir: file.includeDir, depth: file.depth + 1})
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+func (r *resolver) setDefaults() {
+	c := r.config
+	c.setDefault("hostname", r.host)
+	c.values["hostname"] = []string{strings.NewReplacer(
+		"%%", "%",
+		"%h", r.host,
+	).Replace(c.Get("hostname"))}
+
+	c.setDefault("user", r.localUser)
+	c.setDefault("port", "22")
+	c.setDefault("identityfile", defaultIdentityFiles...)
+	c.setDefault("identitiesonly", "no")
+	c.setDefault("forwardagent", "no")
+	c.setDefault("serveraliveinterval", "0")
+	c.setDefault("serveralivecountmax", "3")
+	c.setDefault("userknownhostsfile", "~/.ssh/known_hosts", "~/.ssh/known_hosts2")
+	c.setDefault(
+		"globalknownhostsfile",
+		filepath.Join(r.systemDir, "ssh_known_hosts"),
+		filepath.Join(r.systemDir, "ssh_known_hosts2"),
+	)
+}
+
+func (r *resolver) expandPaths() {
+	for _, directive := range pathValue {
+		values := r.config.values[directive]
+		for i, value := range values {
+			values[i] = r.expandTilde(expandEnv(r.expandTokens(value)))
+		}
+	}
+}
+
+func (r *resolver) expandTilde(path string) string {
+	if path == "~" || strings.HasPrefix(path, "~/") {
+		return filepath.Join(r.home, path[1:])
+	}
+
+	return path
+}
+
+// parseLine splits a config line into the lower case directive and its
+// arguments. Empty lines and comments return an empty directive.
+func parseLine(line string) (string, []string, error) {
+	line = strings.TrimSpace(line)
+	if line == "" || line[0] == '#' {
+		return "", nil, nil
+	}
+
+	i := strings.IndexAny(line, " \t=")
+	if i < 0 {
+		return strings.ToLower(line), nil, nil
+	}
+
+	directive := strings.ToLower(line[:i])
+	rest := strings.TrimLeft(line[i:], " \t")
+	rest = strings.TrimLeft(strings.TrimPrefix(rest, "="), " \t")
+	if rawValue[directive] {
+		return directive, []string{rest}, nil
+	}
+
+	args, err := splitArgs(rest)
+	return directive, args, err
+}
+
+// splitArgs splits the arguments of a directive on whitespace, honouring
+// double quotes. A # at the start of an argument starts a comment.
+func splitArgs(line string) ([]string, error) {
+	args := []string{}
+	for {
+		line = strings.TrimLeft(line, " \t")
+		if line == "" || line[0] == '#' {
+			return args, nil
+		}
+
+		if line[0] == '"' {
+			end := strings.IndexByte(line[1:], '"')
+			if end < 0 {
+				return nil, errors.New("unterminated quoted argument")
+			}
+			args = append(args, line[1:end+1])
+			line = line[end+2:]
+			continue
+		}
+
+		end := strings.IndexAny(line, " \t")
+		if end < 0 {
+			end = len(line)
+		}
+		args = append(args, line[:end])
+		line = line[end:]
+	}
+}
+
+func systemConfigDir() string {
+	if runtime.GOOS == "windows" {
+		return filepath.Join(os.Getenv("PROGRAMDATA"), "ssh")
+	}
+
+	return "/etc/ssh"
+}
+
+func localUser() string {
+	current, err := user.Current()
+	if err != nil {
+		return os.Getenv("USER")
+	}
+
+	// Windows returns DOMAIN\user
+	_, name, found := strings.Cut(current.Username, `\`)
+	if found {
+		return name
+	}
+
+	return current.Username
+}
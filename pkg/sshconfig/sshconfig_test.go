@@ -0,0 +1,348 @@
+package sshconfig
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+// The expected values are the output of ssh -G of OpenSSH 9.2 for the same
+// config, with ~ standing for the home directory. ssh -G prints IdentityFile
+// before expanding its tokens, the values here are expanded.
+func TestResolve(t *testing.T) {
+	tests := []struct {
+		name   string
+		config string
+		system string
+		files  map[string]string
+		host   string
+		user   string
+		port   string
+		want   map[string]string
+	}{
+		{
+			name: "first value wins",
+			config: `
+Host web
+  HostName web.example.com
+  Port 2200
+Host w*
+  User deploy
+  Port 22
+Host *
+  User nobody
+`,
+			host: "web",
+			want: map[string]string{
+				"hostname": "web.example.com",
+				"user":     "deploy",
+				"port":     "2200",
+			},
+		},
+		{
+			name: "host is matched in lower case",
+			config: `
+Host web
+  HostName web.example.com
+Host *
+  User nobody
+`,
+			host: "Web.Example.COM",
+			want: map[string]string{
+				"hostname": "web.example.com",
+				"user":     "nobody",
+				"port":     "22",
+			},
+		},
+		{
+			name: "user and port take precedence",
+			config: `
+Host web
+  User deploy
+  Port 2200
+`,
+			host: "web",
+			user: "cli",
+			port: "2022",
+			want: map[string]string{
+				"hostname": "web",
+				"user":     "cli",
+				"port":     "2022",
+			},
+		},
+		{
+			name: "negated pattern",
+			config: `
+Host *.example.com !bastion.example.com
+  User app
+Host *
+  User other
+`,
+			host: "bastion.example.com",
+			want: map[string]string{"user": "other"},
+		},
+		{
+			name: "pattern list",
+			config: `
+Host *.example.com !bastion.example.com
+  User app
+Host *
+  User other
+`,
+			host: "a.example.com",
+			want: map[string]string{"user": "app"},
+		},
+		{
+			name: "match criteria",
+			config: `
+Host db
+  HostName db.internal
+  User alice
+Match host *.internal
+  Port 5022
+Match originalhost db user alice
+  IdentityFile ~/.ssh/db
+Match localuser nosuchuser
+  User skipped
+`,
+			host: "db",
+			want: map[string]string{
+				"hostname":     "db.internal",
+				"user":         "alice",
+				"port":         "5022",
+				"identityfile": "~/.ssh/db",
+			},
+		},
+		{
+			name: "match exec",
+			config: `
+Match exec "exit 1"
+  User one
+Match exec "exit 0"
+  User zero
+`,
+			host: "x",
+			want: map[string]string{"user": "zero"},
+		},
+		{
+			name: "match final",
+			config: `
+Match final
+  User late
+Host x
+  HostName x.example.com
+Match !final
+  Port 2201
+`,
+			host: "x",
+			want: map[string]string{
+				"hostname": "x.example.com",
+				"user":     "late",
+				"port":     "2201",
+			},
+		},
+		{
+			name: "include",
+			config: `
+Include conf.d/*.conf
+Host inc
+  User main
+  Port 2202
+`,
+			files: map[string]string{
+				".ssh/conf.d/a.conf": "Host inc\n  User included\n",
+			},
+			host: "inc",
+			want: map[string]string{
+				"user": "included",
+				"port": "2202",
+			},
+		},
+		{
+			name: "tokens",
+			config: `
+Host tok
+  HostName %h.example.com
+  IdentityFile ~/.ssh/%r@%h-%p
+  ControlPath ~/.ssh/cm-%r@%n:%p
+`,
+			host: "tok",
+			user: "dev",
+			want: map[string]string{
+				"hostname":     "tok.example.com",
+				"identityfile": "~/.ssh/dev@tok.example.com-22",
+				"controlpath":  "~/.ssh/cm-dev@tok:22",
+			},
+		},
+		{
+			name: "multiple values and syntax",
+			config: `
+Host multi
+  IdentityFile ~/.ssh/first
+  IdentityFile = "~/.ssh/my key"
+Host m*
+  IdentityFile ~/.ssh/first
+  IdentityFile ~/.ssh/second
+  Port=2203
+  PROXYJUMP jump@bastion:2222
+`,
+			host: "multi",
+			want: map[string]string{
+				"port":         "2203",
+				"identityfile": "~/.ssh/first ~/.ssh/my key ~/.ssh/second",
+				"proxyjump":    "jump@bastion:2222",
+			},
+		},
+		{
+			name: "proxy command before proxy jump",
+			config: `
+Host pc
+  ProxyCommand nc %h %p
+Host *
+  ProxyJump bastion
+`,
+			host: "pc",
+			want: map[string]string{
+				"proxycommand": "nc %h %p",
+				"proxyjump":    "",
+			},
+		},
+		{
+			name: "system config",
+			config: `
+Host sys
+  Port 2204
+`,
+			system: `
+Host *
+  User system
+  Port 22
+`,
+			host: "sys",
+			want: map[string]string{
+				"user": "system",
+				"port": "2204",
+			},
+		},
+		{
+			name: "defaults",
+			host: "plain",
+			want: map[string]string{
+				"hostname": "plain",
+				"port":     "22",
+				"identityfile": "~/.ssh/id_rsa ~/.ssh/id_ecdsa ~/.ssh/id_ecdsa_sk " +
+					"~/.ssh/id_ed25519 ~/.ssh/id_ed25519_sk ~/.ssh/id_xmss ~/.ssh/id_dsa",
+				"userknownhostsfile":  "~/.ssh/known_hosts ~/.ssh/known_hosts2",
+				"serveraliveinterval": "0",
+				"serveralivecountmax": "3",
+			},
+		},
+		{
+			// ssh 9.2 fails on criteria of newer versions, the block is
+			// skipped instead
+			name: "unsupported match criteria",
+			config: `
+Match sessiontype shell
+  User skipped
+Host *
+  User kept
+`,
+			host: "x",
+			want: map[string]string{"user": "kept"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if runtime.GOOS == "windows" && strings.Contains(test.config, "exec") {
+				t.Skip("exec runs /bin/sh")
+			}
+
+			home := t.TempDir()
+			systemDir := t.TempDir()
+			writeFile(t, filepath.Join(home, ".ssh", "config"), test.config)
+			writeFile(t, filepath.Join(systemDir, "ssh_config"), test.system)
+			for name, content := range test.files {
+				writeFile(t, filepath.Join(home, name), content)
+			}
+
+			config, err := newResolver(test.host, home, systemDir).resolve(test.user, test.port)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			for directive, want := range test.want {
+				want = strings.ReplaceAll(want, "~", home)
+				got := config.Get(directive)
+				if got != want {
+					t.Errorf("%s = %q, want %q", directive, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestResolveWarnings(t *testing.T) {
+	home := t.TempDir()
+	writeFile(t, filepath.Join(home, ".ssh", "config"), "Match version 10.*\n  User skipped\n")
+
+	config, err := newResolver("x", home, t.TempDir()).resolve("", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	warnings := config.Warnings()
+	if len(warnings) != 1 || !strings.Contains(warnings[0], "unsupported Match criteria version") {
+		t.Errorf("warnings = %q, want one about the version criteria", warnings)
+	}
+	if config.Get("user") != localUser() {
+		t.Errorf("user = %q, want the local user", config.Get("user"))
+	}
+}
+
+func TestResolveErrors(t *testing.T) {
+	tests := []struct {
+		name   string
+		config string
+	}{
+		{
+			name:   "missing match criteria",
+			config: "Match\n",
+		},
+		{
+			name:   "missing match argument",
+			config: "Match host\n",
+		},
+		{
+			name:   "unterminated quote",
+			config: "IdentityFile \"~/.ssh/key\n",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			home := t.TempDir()
+			writeFile(t, filepath.Join(home, ".ssh", "config"), test.config)
+
+			_, err := newResolver("x", home, t.TempDir()).resolve("", "")
+			if err == nil {
+				t.Error("expected an error")
+			}
+		})
+	}
+}
+
+func writeFile(t *testing.T, path string, content string) {
+	t.Helper()
+
+	err := os.MkdirAll(filepath.Dir(path), 0o700)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = os.WriteFile(path, []byte(content), 0o600)
+	if err != nil {
+		t.Fatal(err)
+	}
+}